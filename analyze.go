@@ -0,0 +1,276 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// analysisHopRate is the number of onset envelope values per second
+	analysisHopRate = 100
+
+	analysisMinBPM = 60
+	analysisMaxBPM = 200
+)
+
+// analyzeCommand implements `beat-phaser analyze [-o beatmap.json] <file>`, which estimates the tempo and first beat
+// of a track and writes a beatmap stub for it.
+func analyzeCommand(args []string) error {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	out := flags.String("o", "", "write the beatmap stub to this file instead of stdout")
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: beat-phaser analyze [-o beatmap.json] <file>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	path := flags.Arg(0)
+
+	bpm, offset, confidence, err := analyze(path)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %.3f bpm, first beat at %.3fs, confidence %.2f\n", path, bpm, offset, confidence)
+
+	b := beatmap{
+		Audio:         filepath.Base(path),
+		Offset:        math.Round(offset*1000) / 1000,
+		TimeSignature: timeSignature{Beats: 4, Unit: 4},
+		Tempo:         []tempoChange{{Beat: 0, BPM: math.Round(bpm*1000) / 1000}},
+		Confidence:    math.Round(confidence*100) / 100,
+	}
+
+	return writeJSON(*out, b)
+}
+
+// analyze decodes a track and estimates its tempo, the time in seconds of the first beat and how confident the
+// estimate is, between 0 and 1.
+func analyze(path string) (bpm, offset, confidence float64, err error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	streamer, format, err := decode(path, f)
+
+	if err != nil {
+		f.Close()
+		return 0, 0, 0, err
+	}
+
+	defer streamer.Close()
+
+	// mix down to mono
+	var mono []float64
+	samples := make([][2]float64, 4096)
+
+	for {
+		n, ok := streamer.Stream(samples)
+
+		for _, s := range samples[:n] {
+			mono = append(mono, (s[0]+s[1])/2)
+		}
+
+		if !ok {
+			break
+		}
+	}
+
+	if err := streamer.Err(); err != nil {
+		return 0, 0, 0, err
+	}
+
+	bpm, offset, confidence, err = analyzeSamples(mono, float64(format.SampleRate))
+
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return bpm, offset, confidence, nil
+}
+
+// analyzeSamples estimates the tempo, first beat and confidence of a track from its samples, mixed down to mono.
+func analyzeSamples(mono []float64, sampleRate float64) (bpm, offset, confidence float64, err error) {
+	env := onsetEnvelope(mono, sampleRate)
+
+	if len(env) < analysisHopRate*10 {
+		return 0, 0, 0, fmt.Errorf("track is too short to analyze")
+	}
+
+	period := estimatePeriod(env)
+
+	// refine the period by finding the beat grid which lines up with the most onsets over the whole track, first
+	// coarsely and then finely around the best match.
+	period, phase, score := refineBeatGrid(env, period, 0.02, 0.0005)
+	period, phase, score = refineBeatGrid(env, period, 0.0005, 0.00001)
+
+	// confidence is how much the best grid stands out from grids at the same tempo but on the wrong phase
+	var mean float64
+	var n int
+
+	for p := 0.0; p < period; p++ {
+		mean += combScore(env, period, p)
+		n++
+	}
+
+	mean /= float64(n)
+
+	if score > 0 {
+		confidence = math.Max(0, 1-mean/score)
+	}
+
+	return 60 * analysisHopRate / period, phase / analysisHopRate, confidence, nil
+}
+
+// onsetEnvelope calculates how strongly a note starts at each hop of the track, as the sum of the increases in
+// energy across low, mid and high frequency bands.
+func onsetEnvelope(mono []float64, sampleRate float64) []float64 {
+	hop := int(sampleRate / analysisHopRate)
+
+	lowCoeff := 1 - math.Exp(-2*math.Pi*200/sampleRate)
+	midCoeff := 1 - math.Exp(-2*math.Pi*2000/sampleRate)
+
+	var low, mid float64
+	var energies [][3]float64
+	var e [3]float64
+
+	for i, x := range mono {
+		low += lowCoeff * (x - low)
+		mid += midCoeff * (x - mid)
+
+		bands := [3]float64{low, mid - low, x - mid}
+
+		for b := range bands {
+			e[b] += bands[b] * bands[b]
+		}
+
+		if (i+1)%hop == 0 {
+			energies = append(energies, e)
+			e = [3]float64{}
+		}
+	}
+
+	env := make([]float64, len(energies))
+
+	for t := 1; t < len(energies); t++ {
+		for b := range energies[t] {
+			env[t] += math.Max(0, math.Log(1e-9+energies[t][b])-math.Log(1e-9+energies[t-1][b]))
+		}
+	}
+
+	// remove the local average so that only sharp onsets remain
+	window := analysisHopRate / 4
+	sharp := make([]float64, len(env))
+
+	for t := range env {
+		from, to := t-window, t+window
+
+		if from < 0 {
+			from = 0
+		}
+
+		if to > len(env) {
+			to = len(env)
+		}
+
+		var avg float64
+
+		for _, v := range env[from:to] {
+			avg += v
+		}
+
+		avg /= float64(to - from)
+
+		sharp[t] = math.Max(0, env[t]-avg)
+	}
+
+	return sharp
+}
+
+// estimatePeriod finds the beat period of the onset envelope, in hops, by autocorrelation. tempos near 120bpm are
+// preferred to avoid picking double or half time.
+func estimatePeriod(env []float64) float64 {
+	minLag := int(60 * analysisHopRate / analysisMaxBPM)
+	maxLag := int(60 * analysisHopRate / analysisMinBPM)
+
+	corr := make([]float64, maxLag+2)
+
+	for lag := minLag - 1; lag <= maxLag+1; lag++ {
+		for t := 0; t+lag < len(env); t++ {
+			corr[lag] += env[t] * env[t+lag]
+		}
+
+		corr[lag] /= float64(len(env) - lag)
+	}
+
+	best, bestScore := minLag, math.Inf(-1)
+
+	for lag := minLag; lag <= maxLag; lag++ {
+		bpm := 60 * analysisHopRate / float64(lag)
+		weight := math.Exp(-0.5 * math.Pow(math.Log2(bpm/120), 2))
+
+		if score := corr[lag] * weight; score > bestScore {
+			best, bestScore = lag, score
+		}
+	}
+
+	// parabolic interpolation for a fractional lag
+	a, b, c := corr[best-1], corr[best], corr[best+1]
+
+	if d := a - 2*b + c; d != 0 {
+		return float64(best) + 0.5*(a-c)/d
+	}
+
+	return float64(best)
+}
+
+// refineBeatGrid searches periods within a fraction of period, returning the period and phase (both in hops) of
+// the beat grid with the best comb score.
+func refineBeatGrid(env []float64, period, within, step float64) (bestPeriod, bestPhase, bestScore float64) {
+	bestPeriod = period
+
+	for p := period * (1 - within); p <= period*(1+within); p += period * step {
+		for phase := 0.0; phase < p; phase += 0.5 {
+			if score := combScore(env, p, phase); score > bestScore {
+				bestPeriod, bestPhase, bestScore = p, phase, score
+			}
+		}
+	}
+
+	return bestPeriod, bestPhase, bestScore
+}
+
+// combScore is the average onset strength on each beat of a beat grid.
+func combScore(env []float64, period, phase float64) float64 {
+	var sum float64
+	var n int
+
+	for t := phase; t < float64(len(env)-1); t += period {
+		i := int(t)
+		frac := t - float64(i)
+
+		sum += env[i]*(1-frac) + env[i+1]*frac
+		n++
+	}
+
+	if n == 0 {
+		return 0
+	}
+
+	return sum / float64(n)
+}
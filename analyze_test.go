@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// clickTrack is a click on every beat of a tempo, starting at offset seconds, as mono samples.
+func clickTrack(sampleRate, bpm, offset, seconds float64) []float64 {
+	mono := make([]float64, int(sampleRate*seconds))
+	clickLength := int(sampleRate * 0.01)
+
+	for beat := offset; beat < seconds; beat += 60 / bpm {
+		start := int(beat * sampleRate)
+
+		for i := 0; i < clickLength && start+i < len(mono); i++ {
+			// a short burst of a high tone, fading out
+			t := float64(i) / sampleRate
+			mono[start+i] = math.Sin(2*math.Pi*3000*t) * (1 - float64(i)/float64(clickLength))
+		}
+	}
+
+	return mono
+}
+
+func TestAnalyzeSamples(t *testing.T) {
+	const sampleRate, bpm, offset = 44100, 128, 0.25
+
+	gotBPM, gotOffset, confidence, err := analyzeSamples(clickTrack(sampleRate, bpm, offset, 20), sampleRate)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if math.Abs(gotBPM-bpm) > 0.5 {
+		t.Errorf("bpm = %.3f, want %v", gotBPM, bpm)
+	}
+
+	// the first beat may be found a whole beat later if the first click is missed
+	beat := 60.0 / bpm
+
+	if d := math.Mod(gotOffset-offset+beat/2, beat) - beat/2; math.Abs(d) > 0.02 {
+		t.Errorf("offset = %.3f, want %v", gotOffset, offset)
+	}
+
+	if confidence <= 0.5 {
+		t.Errorf("confidence = %.2f for a click track, want more than 0.5", confidence)
+	}
+}
+
+func TestAnalyzeSamplesTooShort(t *testing.T) {
+	if _, _, _, err := analyzeSamples(clickTrack(44100, 120, 0, 2), 44100); err == nil {
+		t.Error("a 2 second track was analyzed, want an error")
+	}
+}
+
+func TestAnalyzeCommandUsage(t *testing.T) {
+	if err := analyzeCommand(nil); !errors.Is(err, errUsage) {
+		t.Errorf("analyze without a file returned %v, want errUsage", err)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"math"
+	"path"
+	"strconv"
+
+	"github.com/faiface/pixel"
+)
+
+// defaultAnimationFPS is the frame rate of animations which don't set one
+const defaultAnimationFPS = 10
+
+// animationMode is what an animation does after its last frame
+type animationMode string
+
+const (
+	// animationLoop starts again from the first frame
+	animationLoop animationMode = "loop"
+
+	// animationOnce holds the last frame
+	animationOnce animationMode = "once"
+
+	// animationPingPong plays backwards to the first frame, then forwards again
+	animationPingPong animationMode = "pingPong"
+)
+
+type animation struct {
+	name   string
+	frames []pixel.Rect
+	fps    float64
+	mode   animationMode
+
+	// events are named points in the animation, keyed by the frame they happen on
+	events map[int]string
+}
+
+// step is the number of frames which have been shown after t seconds, including the ones played backwards.
+func (a *animation) step(t float64) int {
+	return int(math.Floor(t * a.fps))
+}
+
+// index is the frame shown on a step.
+func (a *animation) index(step int) int {
+	n := len(a.frames)
+
+	if step < 0 {
+		return 0
+	}
+
+	switch a.mode {
+	case animationOnce:
+		if step >= n {
+			return n - 1
+		}
+
+		return step
+	case animationPingPong:
+		if n < 2 {
+			return 0
+		}
+
+		period := 2*n - 2
+		step %= period
+
+		if step >= n {
+			return period - step
+		}
+
+		return step
+	default:
+		return step % n
+	}
+}
+
+// frameAt is the frame shown t seconds into the animation.
+func (a *animation) frameAt(t float64) pixel.Rect {
+	return a.frames[a.index(a.step(t))]
+}
+
+// indexAt is the index of the frame shown t seconds into the animation.
+func (a *animation) indexAt(t float64) int {
+	return a.index(a.step(t))
+}
+
+// finished reports whether an animation which plays once has reached its last frame by t seconds in. other
+// animations never finish.
+func (a *animation) finished(t float64) bool {
+	return a.mode == animationOnce && a.step(t) >= len(a.frames)-1
+}
+
+// eventsBetween calls fn with the events on every frame shown after from seconds, up to and including to seconds.
+func (a *animation) eventsBetween(from, to float64, fn func(event string)) {
+	if len(a.events) == 0 {
+		return
+	}
+
+	last := a.step(to)
+
+	// a once animation doesn't reach any new frames after its last one
+	if a.mode == animationOnce && last >= len(a.frames) {
+		last = len(a.frames) - 1
+	}
+
+	for step := a.step(from) + 1; step <= last; step++ {
+		if event, ok := a.events[a.index(step)]; ok {
+			fn(event)
+		}
+	}
+}
+
+// animationPlayer plays the animations of a sheet, telling subscribers about each event as it's reached.
+type animationPlayer struct {
+	anims   map[string]*animation
+	current *animation
+
+	// t is how long the current animation has been playing for
+	t float64
+
+	listeners map[string][]func()
+}
+
+func (p *animationPlayer) setAnimations(anims map[string]*animation) {
+	p.anims = anims
+
+	if p.current != nil {
+		p.current = anims[p.current.name]
+	}
+}
+
+// play switches to an animation, starting it from the beginning. playing the current animation does nothing.
+func (p *animationPlayer) play(name string) {
+	if p.current != nil && p.current.name == name {
+		return
+	}
+
+	p.restart(name)
+}
+
+// restart plays an animation from the beginning, even if it's already playing. sheets are checked for the
+// animations they need when they're loaded, so playing one which isn't in the sheet is a bug.
+func (p *animationPlayer) restart(name string) {
+	a, ok := p.anims[name]
+
+	if !ok {
+		panic("animation: unknown animation " + name)
+	}
+
+	p.current = a
+	p.t = 0
+
+	if event, ok := p.current.events[0]; ok {
+		p.emit(event)
+	}
+}
+
+func (p *animationPlayer) update(dt float64) {
+	if p.current == nil {
+		return
+	}
+
+	from := p.t
+	p.t += dt
+
+	p.current.eventsBetween(from, p.t, p.emit)
+}
+
+// frame is the frame being shown, or an empty rect if nothing has been played.
+func (p *animationPlayer) frame() pixel.Rect {
+	if p.current == nil {
+		return pixel.Rect{}
+	}
+
+	return p.current.frameAt(p.t)
+}
+
+// index is the index of the frame being shown in the current animation.
+func (p *animationPlayer) index() int {
+	if p.current == nil {
+		return 0
+	}
+
+	return p.current.indexAt(p.t)
+}
+
+func (p *animationPlayer) finished() bool {
+	return p.current == nil || p.current.finished(p.t)
+}
+
+// on subscribes to an event in any of the animations.
+func (p *animationPlayer) on(event string, fn func()) {
+	if p.listeners == nil {
+		p.listeners = make(map[string][]func())
+	}
+
+	p.listeners[event] = append(p.listeners[event], fn)
+}
+
+func (p *animationPlayer) emit(event string) {
+	for _, fn := range p.listeners[event] {
+		fn()
+	}
+}
+
+// animationSheetDesc is a sheet's json description, e.g. images/sprites/spike.json:
+//
+//	{
+//		"frameWidth": 104,
+//		"animations": {
+//			"Run": {"start": 4, "end": 15, "fps": 10, "events": {"3": "footstep"}},
+//			"Die": {"start": 16, "end": 24, "mode": "once"}
+//		}
+//	}
+//
+// frames are numbered from the top left of the sheet, along each row. an animation with a row numbers its frames
+// from the start of that row instead. frameWidth and frameHeight default to the ones the sheet is loaded with, and
+// the height of the sheet.
+type animationSheetDesc struct {
+	FrameWidth  float64                         `json:"frameWidth"`
+	FrameHeight float64                         `json:"frameHeight"`
+	Animations  map[string]animationDescription `json:"animations"`
+}
+
+type animationDescription struct {
+	Row    int            `json:"row"`
+	Start  int            `json:"start"`
+	End    int            `json:"end"`
+	FPS    float64        `json:"fps"`
+	Mode   animationMode  `json:"mode"`
+	Events map[int]string `json:"events"`
+}
+
+// loadAnimationSheet loads a sprite sheet and its animations, which are described by a json file next to the sheet,
+// or a csv file of name,start,end[,fps[,mode]] rows.
+func loadAnimationSheet(fsys fs.FS, name string, frameWidth float64, sheetBasePath string) (sheet pixel.Picture, anims map[string]*animation, err error) {
+	// open and load the spritesheet
+	sheetFile, err := fsys.Open(path.Join(sheetBasePath, name+".png"))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer sheetFile.Close()
+
+	sheetImg, _, err := image.Decode(sheetFile)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sheet = pixel.PictureDataFromImage(sheetImg)
+
+	desc, err := loadAnimationSheetDesc(fsys, path.Join(sheetBasePath, name))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if desc.FrameWidth == 0 {
+		desc.FrameWidth = frameWidth
+	}
+
+	if desc.FrameHeight == 0 {
+		desc.FrameHeight = sheet.Bounds().H()
+	}
+
+	if desc.FrameWidth <= 0 {
+		return nil, nil, fmt.Errorf("%s: frameWidth must be positive", name)
+	}
+
+	// create a slice of frames inside the spritesheet, from the top left
+	var frames []pixel.Rect
+
+	columns := int(sheet.Bounds().W() / desc.FrameWidth)
+
+	for y := sheet.Bounds().Max.Y; y-desc.FrameHeight >= sheet.Bounds().Min.Y; y -= desc.FrameHeight {
+		for x := sheet.Bounds().Min.X; x+desc.FrameWidth <= sheet.Bounds().Max.X; x += desc.FrameWidth {
+			frames = append(frames, pixel.R(
+				x,
+				y-desc.FrameHeight,
+				x+desc.FrameWidth,
+				y,
+			))
+		}
+	}
+
+	anims = make(map[string]*animation)
+
+	for animName, a := range desc.Animations {
+		if a.Row < 0 {
+			return nil, nil, fmt.Errorf("%s: %s: row can't be negative", name, animName)
+		}
+
+		start, end := a.Row*columns+a.Start, a.Row*columns+a.End
+
+		if start < 0 || end < start || end >= len(frames) {
+			return nil, nil, fmt.Errorf("%s: %s: frames %d to %d aren't in the %d frames of the sheet", name, animName, start, end, len(frames))
+		}
+
+		switch a.Mode {
+		case "":
+			a.Mode = animationLoop
+		case animationLoop, animationOnce, animationPingPong:
+		default:
+			return nil, nil, fmt.Errorf("%s: %s: unknown mode %q", name, animName, a.Mode)
+		}
+
+		switch {
+		case a.FPS < 0:
+			return nil, nil, fmt.Errorf("%s: %s: fps can't be negative", name, animName)
+		case a.FPS == 0:
+			a.FPS = defaultAnimationFPS
+		}
+
+		for frame := range a.Events {
+			if frame < 0 || frame > end-start {
+				return nil, nil, fmt.Errorf("%s: %s: event on frame %d, which isn't in the animation", name, animName, frame)
+			}
+		}
+
+		anims[animName] = &animation{
+			name:   animName,
+			frames: frames[start : end+1],
+			fps:    a.FPS,
+			mode:   a.Mode,
+			events: a.Events,
+		}
+	}
+
+	return sheet, anims, nil
+}
+
+// loadAnimationSheetDesc loads the json description of a sheet, falling back to its csv description.
+func loadAnimationSheetDesc(fsys fs.FS, name string) (*animationSheetDesc, error) {
+	desc := &animationSheetDesc{}
+
+	f, err := fsys.Open(name + ".json")
+
+	if err == nil {
+		defer f.Close()
+
+		if err := json.NewDecoder(f).Decode(desc); err != nil {
+			return nil, fmt.Errorf("%s.json: %v", name, err)
+		}
+
+		return desc, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	descFile, err := fsys.Open(name + ".csv")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer descFile.Close()
+
+	desc.Animations = make(map[string]animationDescription)
+
+	// load the animation information, name and interval inside the spritesheet
+	r := csv.NewReader(descFile)
+	r.FieldsPerRecord = -1
+
+	for line := 1; ; line++ {
+		anim, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(anim) < 3 {
+			return nil, fmt.Errorf("%s.csv:%d: expected name, start and end", name, line)
+		}
+
+		var a animationDescription
+
+		a.Start, err = strconv.Atoi(anim[1])
+
+		if err != nil {
+			return nil, fmt.Errorf("%s.csv:%d: %v", name, line, err)
+		}
+
+		a.End, err = strconv.Atoi(anim[2])
+
+		if err != nil {
+			return nil, fmt.Errorf("%s.csv:%d: %v", name, line, err)
+		}
+
+		if len(anim) > 3 {
+			a.FPS, err = strconv.ParseFloat(anim[3], 64)
+
+			if err != nil {
+				return nil, fmt.Errorf("%s.csv:%d: %v", name, line, err)
+			}
+		}
+
+		if len(anim) > 4 {
+			a.Mode = animationMode(anim[4])
+		}
+
+		desc.Animations[anim[0]] = a
+	}
+
+	return desc, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// sheetFS is a filesystem holding a sheet of frames 10 pixels wide, in 2 rows of 4, described by desc.
+func sheetFS(t *testing.T, desc string) fstest.MapFS {
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20))); err != nil {
+		t.Fatal(err)
+	}
+
+	return fstest.MapFS{
+		"sheet.png":  {Data: buf.Bytes()},
+		"sheet.json": {Data: []byte(desc)},
+	}
+}
+
+func TestLoadAnimationSheet(t *testing.T) {
+	fsys := sheetFS(t, `{"frameHeight": 10, "animations": {
+		"Idle": {"start": 0, "end": 3, "fps": 5},
+		"Jump": {"row": 1, "start": 1, "end": 2, "mode": "once", "events": {"1": "land"}}
+	}}`)
+
+	_, anims, err := loadAnimationSheet(fsys, "sheet", 10, ".")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(anims["Idle"].frames); n != 4 {
+		t.Errorf("Idle has %d frames, want 4", n)
+	}
+
+	jump := anims["Jump"]
+
+	if len(jump.frames) != 2 || jump.mode != animationOnce || jump.fps != defaultAnimationFPS || jump.events[1] != "land" {
+		t.Errorf("Jump = %+v, want 2 frames played once at the default fps, landing on the second", jump)
+	}
+}
+
+func TestLoadAnimationSheetErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name, anim, err string
+	}{
+		{"negative row", `{"row": -1, "start": 5, "end": 6}`, "row can't be negative"},
+		{"negative start", `{"start": -1, "end": 2}`, "aren't in the"},
+		{"past the end", `{"row": 1, "start": 2, "end": 4}`, "aren't in the"},
+		{"backwards", `{"start": 3, "end": 1}`, "aren't in the"},
+		{"negative fps", `{"start": 0, "end": 1, "fps": -5}`, "fps can't be negative"},
+		{"unknown mode", `{"start": 0, "end": 1, "mode": "sideways"}`, "unknown mode"},
+		{"event outside", `{"start": 0, "end": 1, "events": {"2": "late"}}`, "isn't in the animation"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := sheetFS(t, `{"frameHeight": 10, "animations": {"Bad": `+tt.anim+`}}`)
+
+			_, _, err := loadAnimationSheet(fsys, "sheet", 10, ".")
+
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSheetAssetRequiredAnimations(t *testing.T) {
+	if _, err := sheetAsset("spike", 104, "images/sprites", "Run").load(); err != nil {
+		t.Errorf("loading spike with its Run animation: %v", err)
+	}
+
+	_, err := sheetAsset("spike", 104, "images/sprites", "Fly").load()
+
+	if err == nil || !strings.Contains(err.Error(), "animation Fly is missing") {
+		t.Errorf("got error %v loading spike with a Fly animation, want it to be missing", err)
+	}
+}
+
+func TestAnimationPlayerUnknownAnimation(t *testing.T) {
+	p := &animationPlayer{anims: map[string]*animation{}}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("playing an unknown animation didn't panic")
+		}
+	}()
+
+	p.play("Missing")
+}
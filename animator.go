@@ -0,0 +1,137 @@
+package main
+
+import (
+	"github.com/faiface/pixel"
+)
+
+// animatorState is a state of an animator, which plays one of the sheet's animations while it's active
+type animatorState struct {
+	name      string
+	animation string
+
+	// frame, if it's set, picks the frame to show instead of the animation's timing
+	frame func(a *animation, t float64) pixel.Rect
+
+	// enter is called when the animator switches to the state, update every update while it's active, and finish
+	// once when an animation which plays once reaches its last frame
+	enter  func()
+	update func(dt float64)
+	finish func()
+}
+
+// animatorTransition moves the animator to a state when its condition holds. an empty from means any state.
+type animatorTransition struct {
+	from, to string
+	when     func() bool
+}
+
+// animator is a state machine over an animation player. transitions are checked in the order they were added and the
+// first one whose condition holds wins, so earlier transitions take priority. a transition to the current state
+// keeps the animator where it is.
+type animator struct {
+	animationPlayer
+
+	states      map[string]*animatorState
+	transitions []animatorTransition
+
+	currentState *animatorState
+	finishCalled bool
+}
+
+func newAnimator(anims map[string]*animation) *animator {
+	a := &animator{
+		states: make(map[string]*animatorState),
+	}
+
+	a.setAnimations(anims)
+
+	return a
+}
+
+// addState adds a state which plays an animation. the returned state can be given callbacks.
+func (a *animator) addState(name, animation string) *animatorState {
+	s := &animatorState{name: name, animation: animation}
+	a.states[name] = s
+
+	return s
+}
+
+func (a *animator) addTransition(from, to string, when func() bool) {
+	a.transitions = append(a.transitions, animatorTransition{from: from, to: to, when: when})
+}
+
+func (a *animator) state() string {
+	if a.currentState == nil {
+		return ""
+	}
+
+	return a.currentState.name
+}
+
+// enter switches to a state, restarting its animation even if it's the current state.
+func (a *animator) enter(name string) {
+	s, ok := a.states[name]
+
+	if !ok {
+		panic("animator: unknown state " + name)
+	}
+
+	a.currentState = s
+	a.finishCalled = false
+	a.restart(s.animation)
+
+	if s.enter != nil {
+		s.enter()
+	}
+}
+
+func (a *animator) update(dt float64) {
+	for _, t := range a.transitions {
+		if t.from != "" && t.from != a.state() {
+			continue
+		}
+
+		if !t.when() {
+			continue
+		}
+
+		if t.to != a.state() {
+			a.enter(t.to)
+		}
+
+		break
+	}
+
+	a.animationPlayer.update(dt)
+
+	if a.currentState == nil {
+		return
+	}
+
+	if a.currentState.update != nil {
+		a.currentState.update(dt)
+	}
+
+	if !a.finishCalled && a.animationPlayer.finished() {
+		a.finishCalled = true
+
+		if a.currentState.finish != nil {
+			a.currentState.finish()
+		}
+	}
+}
+
+// played reports whether the current animation has shown all of its frames at least once.
+func (a *animator) played() bool {
+	anim := a.animationPlayer.current
+
+	return anim != nil && a.t >= float64(len(anim.frames))/anim.fps
+}
+
+func (a *animator) frame() pixel.Rect {
+	if a.currentState != nil && a.currentState.frame != nil && a.animationPlayer.current != nil {
+		return a.currentState.frame(a.animationPlayer.current, a.t)
+	}
+
+	return a.animationPlayer.frame()
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io/fs"
+	"math"
+	"math/rand"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/faiface/pixel"
+)
+
+// archetypeDir holds a json file for each kind of enemy
+const archetypeDir = "enemies"
+
+const (
+	// attackScythe swings a scythe at the player up close
+	attackScythe = "scythe"
+
+	// attackBolt fires a bolt at the player from range
+	attackBolt = "bolt"
+)
+
+// archetypes are every kind of enemy, keyed by the name of their file in archetypeDir
+var archetypes map[string]*archetype
+
+// archetypeAnimations are the animations every archetype's sheet needs
+var archetypeAnimations = []string{"Norm", "AttackBuild", "Attack"}
+
+// archetype is a kind of enemy, e.g. enemies/reaper.json:
+//
+//	{
+//		"sheet": "reaper",
+//		"frameWidth": 188,
+//		"hitbox": [-84, -74, 84, 74],
+//		"moveSpeed": 320,
+//		"acceleration": 144,
+//		"attack": {
+//			"kind": "scythe", "range": 200, "speed": 14.4, "damage": 20, "weapon": "images/scythe",
+//			"rhythm": {"every": 2, "telegraph": 1}
+//		},
+//		"score": 100
+//	}
+//
+// the sheet is in images/sprites, and needs Norm, AttackBuild and Attack animations.
+type archetype struct {
+	name string
+
+	Sheet      string  `json:"sheet"`
+	FrameWidth float64 `json:"frameWidth"`
+
+	// Scale is how much the sprite is scaled by when it's drawn, 1 if it isn't set
+	Scale float64 `json:"scale"`
+
+	// Hitbox is relative to the enemy's center
+	Hitbox levelRect `json:"hitbox"`
+
+	// MoveSpeed and Acceleration are in pixels per second
+	MoveSpeed    float64 `json:"moveSpeed"`
+	Acceleration float64 `json:"acceleration"`
+
+	// HealthScale scales the health enemies spawn with, which grows with the difficulty. it's 1 if it isn't set.
+	HealthScale float64 `json:"healthScale"`
+
+	Attack archetypeAttack `json:"attack"`
+
+	// Score is added to the player's score when they kill one
+	Score float64 `json:"score"`
+
+	// Colors are the colour the sprite is tinted and the colour of its bolts, as #rrggbb. they're white if not set.
+	Colors [2]string `json:"colors"`
+
+	// Weight is how likely the spawner is to pick this kind of enemy compared to the others, 1 if it isn't set and
+	// never if it's 0. they aren't picked until the difficulty has reached MinDifficulty.
+	Weight        *float64 `json:"weight"`
+	MinDifficulty float64  `json:"minDifficulty"`
+
+	weight          float64
+	tint, boltColor color.RGBA
+}
+
+type archetypeAttack struct {
+	// Kind is attackScythe or attackBolt
+	Kind string `json:"kind"`
+
+	// Range is how close, in pixels, the enemy gets to the player before it starts building up an attack
+	Range float64 `json:"range"`
+
+	// Speed is how fast a scythe swings in radians per second, or how fast a bolt flies in pixels per second
+	Speed float64 `json:"speed"`
+
+	Damage float64 `json:"damage"`
+
+	// Weapon is the picture of the scythe, without the extension. bolts are drawn without one.
+	Weapon string `json:"weapon"`
+
+	Rhythm archetypeRhythm `json:"rhythm"`
+}
+
+// archetypeRhythm is when an enemy's attacks land, in beats of the current track. strikes land every Every beats,
+// split into Divide strikes, shifted by Offset beats. e.g. every other beat is {"every": 2}, off beats are
+// {"every": 1, "offset": 0.5} and triplets are {"every": 1, "divide": 3}.
+type archetypeRhythm struct {
+	Every  float64 `json:"every"`
+	Divide int     `json:"divide"`
+	Offset float64 `json:"offset"`
+
+	// Telegraph is how many beats before a strike the enemy starts building up to it. it has to be more than 0, as
+	// every attack is built up to.
+	Telegraph float64 `json:"telegraph"`
+}
+
+// interval is the number of beats between strikes.
+func (r archetypeRhythm) interval() float64 {
+	return r.Every / float64(r.Divide)
+}
+
+// nextStrike is the first beat a strike can land on at or after a beat.
+func (r archetypeRhythm) nextStrike(beat float64) float64 {
+	return r.Offset + math.Ceil((beat-r.Offset)/r.interval())*r.interval()
+}
+
+func (a *archetype) sheetAsset() assetRequest {
+	return sheetAsset(a.Sheet, a.FrameWidth, "images/sprites")
+}
+
+// weaponAsset is the picture of the archetype's weapon. ok is false if it doesn't have one.
+func (a *archetype) weaponAsset() (r assetRequest, ok bool) {
+	if a.Attack.Weapon == "" {
+		return assetRequest{}, false
+	}
+
+	return pictureAsset(a.Attack.Weapon), true
+}
+
+// archetypeAssets are the assets used by every archetype.
+func archetypeAssets() []assetRequest {
+	var requests []assetRequest
+
+	for _, name := range archetypeNames() {
+		a := archetypes[name]
+		requests = append(requests, a.sheetAsset())
+
+		if weapon, ok := a.weaponAsset(); ok {
+			requests = append(requests, weapon)
+		}
+	}
+
+	return requests
+}
+
+// archetypeNames is the names of all archetypes, in order.
+func archetypeNames() []string {
+	var names []string
+
+	for name := range archetypes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// archetypeBounds is the smallest rect around every archetype's hitbox.
+func archetypeBounds() pixel.Rect {
+	var bounds pixel.Rect
+
+	for _, a := range archetypes {
+		bounds = bounds.Union(a.Hitbox.rect().Norm())
+	}
+
+	return bounds
+}
+
+// pickArchetype picks a random archetype which can spawn at a difficulty, according to their weights.
+func pickArchetype(difficulty float64) *archetype {
+	var available []*archetype
+
+	total := 0.0
+
+	for _, name := range archetypeNames() {
+		if a := archetypes[name]; a.weight > 0 && a.MinDifficulty <= difficulty {
+			available = append(available, a)
+			total += a.weight
+		}
+	}
+
+	if len(available) == 0 {
+		return nil
+	}
+
+	r := rand.Float64() * total
+
+	for _, a := range available {
+		r -= a.weight
+
+		if r < 0 {
+			return a
+		}
+	}
+
+	return available[len(available)-1]
+}
+
+// loadArchetypes loads every archetype in dir, keyed by the name of its file.
+func loadArchetypes(fsys fs.FS, dir string) (map[string]*archetype, error) {
+	paths, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	archetypes := make(map[string]*archetype)
+
+	for _, p := range paths {
+		a, err := loadArchetype(fsys, p)
+
+		if err != nil {
+			return nil, err
+		}
+
+		archetypes[a.name] = a
+	}
+
+	if len(archetypes) == 0 {
+		return nil, fmt.Errorf("no enemies in %s", dir)
+	}
+
+	return archetypes, nil
+}
+
+func loadArchetype(fsys fs.FS, name string) (*archetype, error) {
+	f, err := fsys.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	a := &archetype{
+		name: strings.TrimSuffix(path.Base(name), path.Ext(name)),
+	}
+
+	if err := json.NewDecoder(f).Decode(a); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	if err := a.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return a, nil
+}
+
+// checkSheet checks the archetype's sheet has every animation an enemy plays, loading it if it isn't already.
+func (a *archetype) checkSheet() error {
+	_, anims, err := assets.sheet(a.sheetAsset())
+
+	if err != nil {
+		return err
+	}
+
+	defer assets.release(a.sheetAsset())
+
+	for _, name := range archetypeAnimations {
+		if anims[name] == nil {
+			return fmt.Errorf("%s: sheet %s has no %s animation", a.name, a.Sheet, name)
+		}
+	}
+
+	return nil
+}
+
+// checkArchetypeSheets checks the sheet of every archetype, in order.
+func checkArchetypeSheets(archetypes map[string]*archetype) error {
+	var names []string
+
+	for name := range archetypes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := archetypes[name].checkSheet(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate checks the archetype can be used, and fills in its defaults.
+func (a *archetype) validate() error {
+	if a.Sheet == "" || a.FrameWidth <= 0 {
+		return fmt.Errorf("sheet and frameWidth are required")
+	}
+
+	if a.Hitbox.rect().Norm().Area() == 0 {
+		return fmt.Errorf("hitbox is empty")
+	}
+
+	if a.MoveSpeed <= 0 || a.Acceleration <= 0 {
+		return fmt.Errorf("moveSpeed and acceleration must be positive")
+	}
+
+	switch a.Attack.Kind {
+	case attackScythe, attackBolt:
+	default:
+		return fmt.Errorf("unknown attack %q", a.Attack.Kind)
+	}
+
+	if a.Attack.Range <= 0 || a.Attack.Speed <= 0 {
+		return fmt.Errorf("attack range and speed must be positive")
+	}
+
+	if a.Attack.Kind == attackScythe && a.Attack.Weapon == "" {
+		return fmt.Errorf("scythe attacks need a weapon")
+	}
+
+	if a.Attack.Rhythm.Every <= 0 || a.Attack.Rhythm.Telegraph <= 0 || a.Attack.Rhythm.Divide < 0 {
+		return fmt.Errorf("attack rhythm needs a positive every and telegraph, and divide can't be negative")
+	}
+
+	if a.Attack.Rhythm.Divide == 0 {
+		a.Attack.Rhythm.Divide = 1
+	}
+
+	if a.Scale == 0 {
+		a.Scale = 1
+	}
+
+	if a.HealthScale == 0 {
+		a.HealthScale = 1
+	}
+
+	switch {
+	case a.Weight == nil:
+		a.weight = 1
+	case *a.Weight < 0:
+		return fmt.Errorf("weight can't be negative")
+	default:
+		a.weight = *a.Weight
+	}
+
+	colors := []*color.RGBA{&a.tint, &a.boltColor}
+
+	for i, s := range a.Colors {
+		if s == "" {
+			*colors[i] = color.RGBA{255, 255, 255, 255}
+			continue
+		}
+
+		c, err := parseHexColor(s)
+
+		if err != nil {
+			return err
+		}
+
+		*colors[i] = c
+	}
+
+	return nil
+}
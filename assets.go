@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/pixel"
+)
+
+// assets are loaded once and shared by everything using them. each user acquires an asset and releases it when it's
+// done with it, and the asset is dropped once nothing is using it.
+var assets = newAssetManager()
+
+type assetManager struct {
+	mu     sync.Mutex
+	assets map[string]*asset
+}
+
+type asset struct {
+	request assetRequest
+	refs    int
+
+	// ready is closed once the asset has loaded, or failed to
+	ready chan struct{}
+	value interface{}
+	err   error
+}
+
+// assetRequest describes how to load an asset, and the key it is cached by.
+type assetRequest struct {
+	key  string
+	load func() (interface{}, error)
+
+	// files are the files in gameFS the asset is loaded from, so it can be reloaded when they change
+	files []string
+
+	// compatible checks that a reloaded asset can replace the one being used, if set
+	compatible func(old, new interface{}) error
+}
+
+func newAssetManager() *assetManager {
+	return &assetManager{
+		assets: make(map[string]*asset),
+	}
+}
+
+// pictureAsset is a png picture, with path given without the extension like loadPicture.
+func pictureAsset(path string) assetRequest {
+	return assetRequest{
+		key:   "picture:" + path,
+		files: []string{path + ".png"},
+		load: func() (interface{}, error) {
+			return loadPicture(gameFS, path)
+		},
+	}
+}
+
+// animationSheet is a sprite sheet and the frames of each of its animations
+type animationSheet struct {
+	sheet pixel.Picture
+	anims map[string]*animation
+}
+
+// sheetAsset is a sprite sheet and its animations. it fails to load if any of the required animations are missing.
+func sheetAsset(name string, frameWidth float64, dir string, required ...string) assetRequest {
+	return assetRequest{
+		key:   fmt.Sprintf("sheet:%s@%g", path.Join(dir, name), frameWidth),
+		files: []string{path.Join(dir, name+".png"), path.Join(dir, name+".json"), path.Join(dir, name+".csv")},
+		load: func() (interface{}, error) {
+			sheet, anims, err := loadAnimationSheet(gameFS, name, frameWidth, dir)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, anim := range required {
+				if anims[anim] == nil {
+					return nil, fmt.Errorf("%s: animation %s is missing", path.Join(dir, name), anim)
+				}
+			}
+
+			return &animationSheet{sheet: sheet, anims: anims}, nil
+		},
+
+		// everything using the sheet expects its animations to be there
+		compatible: func(old, new interface{}) error {
+			for anim := range old.(*animationSheet).anims {
+				if new.(*animationSheet).anims[anim] == nil {
+					return fmt.Errorf("%s: animation %s is missing", path.Join(dir, name), anim)
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// soundAsset is an ogg or mp3 file, decoded into memory.
+func soundAsset(path string) assetRequest {
+	return assetRequest{
+		key:   "sound:" + path,
+		files: []string{path},
+		load: func() (interface{}, error) {
+			return loadSound(gameFS, path)
+		},
+	}
+}
+
+// acquire loads an asset, or waits for it to be loaded if it's already loading, and adds a reference to it.
+func (m *assetManager) acquire(r assetRequest) (interface{}, error) {
+	m.mu.Lock()
+
+	a, loaded := m.assets[r.key]
+
+	if !loaded {
+		a = &asset{request: r, ready: make(chan struct{})}
+		m.assets[r.key] = a
+	}
+
+	a.refs++
+
+	m.mu.Unlock()
+
+	if loaded {
+		<-a.ready
+	} else {
+		a.value, a.err = r.load()
+		close(a.ready)
+	}
+
+	if a.err != nil {
+		m.mu.Lock()
+
+		// don't keep failures around, so the asset can be loaded again
+		if m.assets[r.key] == a {
+			delete(m.assets, r.key)
+		}
+
+		a.refs--
+
+		m.mu.Unlock()
+
+		return nil, a.err
+	}
+
+	// the value may be replaced by a reload
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return a.value, nil
+}
+
+// release removes a reference to an asset, dropping it if nothing else is using it.
+func (m *assetManager) release(r assetRequest) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.assets[r.key]
+
+	if !ok {
+		return
+	}
+
+	a.refs--
+
+	if a.refs <= 0 {
+		delete(m.assets, r.key)
+	}
+}
+
+// reload loads every asset using any of the changed files again, returning what failed to reload. assets which fail
+// to reload keep their old value.
+func (m *assetManager) reload(changed []string) []error {
+	isChanged := make(map[string]bool)
+
+	for _, file := range changed {
+		isChanged[file] = true
+	}
+
+	var reloading []*asset
+
+	m.mu.Lock()
+
+	for _, a := range m.assets {
+		for _, file := range a.request.files {
+			if isChanged[file] {
+				reloading = append(reloading, a)
+				break
+			}
+		}
+	}
+
+	m.mu.Unlock()
+
+	var errs []error
+
+	for _, a := range reloading {
+		<-a.ready
+
+		v, err := a.request.load()
+
+		if err == nil && a.request.compatible != nil {
+			err = a.request.compatible(a.value, v)
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		m.mu.Lock()
+		a.value = v
+		m.mu.Unlock()
+	}
+
+	return errs
+}
+
+// current is the loaded value of an asset, without adding a reference to it, or nil if it isn't loaded.
+func (m *assetManager) current(r assetRequest) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.assets[r.key]
+
+	if !ok {
+		return nil
+	}
+
+	select {
+	case <-a.ready:
+		return a.value
+	default:
+		return nil
+	}
+}
+
+func (m *assetManager) picture(r assetRequest) (pixel.Picture, error) {
+	v, err := m.acquire(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(pixel.Picture), nil
+}
+
+func (m *assetManager) sheet(r assetRequest) (pixel.Picture, map[string]*animation, error) {
+	v, err := m.acquire(r)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := v.(*animationSheet)
+
+	return s.sheet, s.anims, nil
+}
+
+func (m *assetManager) sound(r assetRequest) (*beep.Buffer, error) {
+	v, err := m.acquire(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*beep.Buffer), nil
+}
+
+// assetPreload is a set of assets being loaded in the background.
+type assetPreload struct {
+	manager  *assetManager
+	requests []assetRequest
+
+	mu       sync.Mutex
+	acquired []assetRequest
+	firstErr error
+	done     chan struct{}
+}
+
+// preload starts loading assets in the background, keeping a reference to each until the preload is released.
+func (m *assetManager) preload(requests ...assetRequest) *assetPreload {
+	p := &assetPreload{
+		manager:  m,
+		requests: requests,
+		done:     make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+
+	for _, r := range requests {
+		wg.Add(1)
+
+		go func(r assetRequest) {
+			defer wg.Done()
+
+			_, err := m.acquire(r)
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			if err != nil {
+				if p.firstErr == nil {
+					p.firstErr = err
+				}
+
+				return
+			}
+
+			p.acquired = append(p.acquired, r)
+		}(r)
+	}
+
+	go func() {
+		wg.Wait()
+		close(p.done)
+	}()
+
+	return p
+}
+
+// progress is how many of the assets have loaded so far.
+func (p *assetPreload) progress() (loaded, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.acquired), len(p.requests)
+}
+
+// finished reports whether every asset has either loaded or failed to.
+func (p *assetPreload) finished() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// err is the first error loading any of the assets.
+func (p *assetPreload) err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.firstErr
+}
+
+// release releases every asset which was loaded, once they have all finished loading.
+func (p *assetPreload) release() {
+	<-p.done
+
+	for _, r := range p.acquired {
+		p.manager.release(r)
+	}
+}
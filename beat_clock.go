@@ -0,0 +1,213 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+// beatClock wraps the streamer of a track and tracks the song position from the samples the speaker has actually
+// consumed, rather than from the wall clock. it is safe to query from the game loop while the speaker is streaming.
+type beatClock struct {
+	mu sync.Mutex
+
+	streamer   beep.StreamSeeker
+	sampleRate beep.SampleRate
+	beatmap    *beatmap
+
+	// latency is how long it takes for streamed samples to reach the speaker (the size of the speaker buffer)
+	latency time.Duration
+
+	// start and n describe the last chunk of samples taken by the speaker, updated at the time updated.
+	start, n int
+	updated  time.Time
+	started  bool
+}
+
+// reset points the clock at a new streamer, clearing any position information.
+func (b *beatClock) reset(streamer beep.StreamSeeker, sampleRate beep.SampleRate, beatmap *beatmap) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.streamer = streamer
+	b.sampleRate = sampleRate
+	b.beatmap = beatmap
+	b.start, b.n = 0, 0
+	b.started = false
+}
+
+// setLatency sets the speaker buffer latency, which is subtracted from the streamed position.
+func (b *beatClock) setLatency(latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.latency = latency
+}
+
+func (b *beatClock) Stream(samples [][2]float64) (n int, ok bool) {
+	b.mu.Lock()
+	streamer := b.streamer
+	b.mu.Unlock()
+
+	start := streamer.Position()
+	n, ok = streamer.Stream(samples)
+
+	b.mu.Lock()
+	b.start, b.n = start, n
+	b.updated = time.Now()
+	b.started = true
+	b.mu.Unlock()
+
+	return n, ok
+}
+
+func (b *beatClock) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.streamer.Err()
+}
+
+func (b *beatClock) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.streamer.Len()
+}
+
+func (b *beatClock) Position() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.streamer.Position()
+}
+
+func (b *beatClock) Seek(p int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.streamer.Seek(p)
+}
+
+// position is the point in the song which is currently audible. the speaker only takes samples a chunk at a time,
+// so the position within the current chunk is interpolated with the time since it was taken.
+func (b *beatClock) position() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.started {
+		return 0
+	}
+
+	elapsed := time.Since(b.updated)
+
+	if chunk := b.sampleRate.D(b.n); elapsed > chunk {
+		elapsed = chunk
+	}
+
+	return b.sampleRate.D(b.start) + elapsed - b.latency
+}
+
+// timing returns the current song position along with the beatmap, or a nil beatmap if nothing is playing yet.
+func (b *beatClock) timing() (time.Duration, *beatmap) {
+	position := b.position()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return position, b.beatmap
+}
+
+// beatDuration is the length of the current beat.
+func (b *beatClock) beatDuration() time.Duration {
+	position, beatmap := b.timing()
+
+	if beatmap == nil {
+		return 0
+	}
+
+	return time.Duration(float64(time.Minute) / beatmap.bpmAt(beatmap.beatAt(position)))
+}
+
+// beat is the number of beats since the first beat of the song, including the fraction of the current beat.
+func (b *beatClock) beat() float64 {
+	position, beatmap := b.timing()
+
+	if beatmap == nil {
+		return 0
+	}
+
+	return beatmap.beatAt(position)
+}
+
+// visualBeat is the beat which should be on screen, shifted by the visual offset so that anything happening on a beat
+// is seen at the same time as it is heard.
+func (b *beatClock) visualBeat() float64 {
+	position, beatmap := b.timing()
+
+	if beatmap == nil {
+		return 0
+	}
+
+	return beatmap.beatAt(position - userSettings.visualOffset())
+}
+
+// currentBeat is the index of the beat currently playing.
+func (b *beatClock) currentBeat() int {
+	return int(math.Floor(b.beat()))
+}
+
+// currentBar is the index of the bar currently playing.
+func (b *beatClock) currentBar() int {
+	position, beatmap := b.timing()
+
+	if beatmap == nil {
+		return 0
+	}
+
+	return beatmap.bar(beatmap.beatAt(position))
+}
+
+// phase is how far through the current beat the song is, between 0 and 1.
+func (b *beatClock) phase() float64 {
+	beat := b.beat()
+
+	return beat - math.Floor(beat)
+}
+
+// timeSinceBeat is the time elapsed since the last beat.
+func (b *beatClock) timeSinceBeat() time.Duration {
+	position, beatmap := b.timing()
+
+	if beatmap == nil {
+		return 0
+	}
+
+	return position - beatmap.timeAt(math.Floor(beatmap.beatAt(position)))
+}
+
+// timeToNextBeat is the time remaining until the next beat.
+func (b *beatClock) timeToNextBeat() time.Duration {
+	position, beatmap := b.timing()
+
+	if beatmap == nil {
+		return 0
+	}
+
+	return beatmap.timeAt(math.Floor(beatmap.beatAt(position))+1) - position
+}
+
+// beatOffset is the signed time from the nearest beat: negative before the beat and positive after it.
+func (b *beatClock) beatOffset() time.Duration {
+	position, beatmap := b.timing()
+
+	if beatmap == nil {
+		return 0
+	}
+
+	beat := beatmap.beatAt(position)
+
+	return position - beatmap.timeAt(math.Floor(beat+0.5))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"sort"
+	"time"
+)
+
+// beatmap describes the timing of a track. it lives next to the audio, e.g. audio/tracks/acid-jazz.json:
+//
+//	{
+//		"audio": "Kevin_MacLeod_-_AcidJazz.mp3",
+//		"offset": 0.012,
+//		"timeSignature": {"beats": 4, "unit": 4},
+//		"tempo": [{"beat": 0, "bpm": 110.724}, {"beat": 128, "bpm": 120}],
+//		"cues": [{"beat": 64, "name": "drop"}]
+//	}
+type beatmap struct {
+	// Audio is the path of the audio file, relative to the beatmap.
+	Audio string `json:"audio"`
+
+	// Offset is the time in seconds from the start of the audio to the first beat.
+	Offset float64 `json:"offset"`
+
+	// Buffered decodes the whole track into memory before playing.
+	Buffered bool `json:"buffered,omitempty"`
+
+	TimeSignature timeSignature `json:"timeSignature"`
+	Tempo         []tempoChange `json:"tempo"`
+	Cues          []cue         `json:"cues,omitempty"`
+
+	// Confidence is written by `beat-phaser analyze` to show how reliable the detected tempo and offset are.
+	Confidence float64 `json:"confidence,omitempty"`
+
+	// start is the time of each tempo change, calculated on load
+	start []time.Duration
+}
+
+type timeSignature struct {
+	Beats int `json:"beats"`
+	Unit  int `json:"unit"`
+}
+
+// tempoChange sets the bpm of the track from a beat onwards.
+type tempoChange struct {
+	Beat float64 `json:"beat"`
+	BPM  float64 `json:"bpm"`
+}
+
+// cue is a named marker on a beat, for anything which wants to react to a specific point in a track.
+type cue struct {
+	Beat float64 `json:"beat"`
+	Name string  `json:"name"`
+}
+
+func loadBeatmap(fsys fs.FS, name string) (*beatmap, error) {
+	f, err := fsys.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var b beatmap
+
+	if err := json.NewDecoder(f).Decode(&b); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	if err := b.init(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	b.Audio = path.Join(path.Dir(name), b.Audio)
+
+	return &b, nil
+}
+
+// init validates the beatmap and calculates when each tempo change occurs.
+func (b *beatmap) init() error {
+	if b.Audio == "" {
+		return errors.New("no audio file specified")
+	}
+
+	if len(b.Tempo) == 0 {
+		return errors.New("no tempo specified")
+	}
+
+	if b.TimeSignature.Beats == 0 {
+		b.TimeSignature = timeSignature{Beats: 4, Unit: 4}
+	}
+
+	sort.Slice(b.Tempo, func(i, j int) bool {
+		return b.Tempo[i].Beat < b.Tempo[j].Beat
+	})
+
+	sort.Slice(b.Cues, func(i, j int) bool {
+		return b.Cues[i].Beat < b.Cues[j].Beat
+	})
+
+	if b.Tempo[0].Beat != 0 {
+		return errors.New("the first tempo change must be on beat 0")
+	}
+
+	b.start = make([]time.Duration, len(b.Tempo))
+	b.start[0] = time.Duration(b.Offset * float64(time.Second))
+
+	for i, change := range b.Tempo {
+		if change.BPM <= 0 {
+			return fmt.Errorf("invalid bpm %v on beat %v", change.BPM, change.Beat)
+		}
+
+		if i > 0 {
+			prev := b.Tempo[i-1]
+			b.start[i] = b.start[i-1] + time.Duration((change.Beat-prev.Beat)*float64(time.Minute)/prev.BPM)
+		}
+	}
+
+	return nil
+}
+
+// tempoIndexAtTime is the index of the tempo change in effect at a point in the song.
+func (b *beatmap) tempoIndexAtTime(t time.Duration) int {
+	return sort.Search(len(b.start), func(i int) bool {
+		return b.start[i] > t
+	}) - 1
+}
+
+// tempoIndexAtBeat is the index of the tempo change in effect on a beat.
+func (b *beatmap) tempoIndexAtBeat(beat float64) int {
+	return sort.Search(len(b.Tempo), func(i int) bool {
+		return b.Tempo[i].Beat > beat
+	}) - 1
+}
+
+// beatAt converts a point in the song to a number of beats since the first beat. it is negative before the first beat.
+func (b *beatmap) beatAt(t time.Duration) float64 {
+	i := b.tempoIndexAtTime(t)
+
+	if i < 0 {
+		i = 0
+	}
+
+	return b.Tempo[i].Beat + float64(t-b.start[i])*b.Tempo[i].BPM/float64(time.Minute)
+}
+
+// timeAt converts a beat to a point in the song.
+func (b *beatmap) timeAt(beat float64) time.Duration {
+	i := b.tempoIndexAtBeat(beat)
+
+	if i < 0 {
+		i = 0
+	}
+
+	return b.start[i] + time.Duration((beat-b.Tempo[i].Beat)*float64(time.Minute)/b.Tempo[i].BPM)
+}
+
+// bpmAt is the tempo of the song on a beat.
+func (b *beatmap) bpmAt(beat float64) float64 {
+	i := b.tempoIndexAtBeat(beat)
+
+	if i < 0 {
+		i = 0
+	}
+
+	return b.Tempo[i].BPM
+}
+
+// bar is the index of the bar containing a beat.
+func (b *beatmap) bar(beat float64) int {
+	return int(math.Floor(beat / float64(b.TimeSignature.Beats)))
+}
+
+// cuesBetween returns the cues in the interval [from, to).
+func (b *beatmap) cuesBetween(from, to float64) []cue {
+	i := sort.Search(len(b.Cues), func(i int) bool {
+		return b.Cues[i].Beat >= from
+	})
+
+	j := sort.Search(len(b.Cues), func(i int) bool {
+		return b.Cues[i].Beat >= to
+	})
+
+	return b.Cues[i:j]
+}
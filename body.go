@@ -2,25 +2,55 @@ package main
 
 import (
 	"math"
-	"path/filepath"
-	"time"
 
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/imdraw"
 	"github.com/faiface/pixel/pixelgl"
 )
 
-type gopherAnimationState int
+// the body's animator states
+const (
+	idle     = "idle"
+	running  = "running"
+	shooting = "shooting"
+	dying    = "dying"
+	dodging  = "dodging"
+)
+
+const (
+	// dodgeDuration is how long, in seconds, a dodge roll lasts. the body moves dodgeSpeed times faster while rolling.
+	dodgeDuration = 0.3
+	dodgeSpeed    = 2
+
+	// dodgeInvulnerable is how long, from the start of a dodge, the body can't be hurt
+	dodgeInvulnerable = 0.2
+
+	// dodgeCooldown is how long after a dodge ends before the body can dodge again
+	dodgeCooldown = 0.6
+
+	// perfectDodgeWindow is how soon, after a dodge starts, an attack has to be avoided for it to be a perfect dodge
+	perfectDodgeWindow = 0.1
+
+	// perfectDodgeProgress and perfectDodgeScore are the reward for a perfect dodge
+	perfectDodgeProgress = 4
+	perfectDodgeScore    = 50
+
+	// perfectDodgeFlash is how long the body is tinted the multiplier's colour after a perfect dodge
+	perfectDodgeFlash = 0.25
+)
 
 const (
-	idle gopherAnimationState = iota
-	running
-	jumping
-	shooting
-	dying
+	// dustLifetime is how long, in seconds, the dust kicked up by a footstep lasts
+	dustLifetime = 0.4
+
+	// dustRadius is how big the dust grows to, in pixels
+	dustRadius = 14
 )
 
-var isDodging = false
+var (
+	spikeSheet = sheetAsset("spike", 104, "images/sprites", "Front", "Run", "Die", "Dodge")
+	armPicture = pictureAsset("images/sprites/arm")
+)
 
 type body struct {
 	imd *imdraw.IMDraw
@@ -30,15 +60,12 @@ type body struct {
 	runSpeed  float64
 	jumpSpeed float64
 
-	rect pixel.Rect
-	vel  pixel.Vec
+	rect, prevRect pixel.Rect
+	vel            pixel.Vec
 
 	// anim
 	sheet    pixel.Picture
-	anims    map[string][]pixel.Rect
-	rate     float64
-	state    gopherAnimationState
-	counter  float64
+	animator *animator
 	dir      float64
 	frame    pixel.Rect
 	runFrame int
@@ -55,23 +82,42 @@ type body struct {
 
 	ctrl pixel.Vec
 
-	dodgeEnd  <-chan time.Time
-	lastDodge time.Time
+	// dodgeTime is how long is left of the current dodge, and dodgeWait how long is left before the next one can
+	// start
+	dodgeTime, dodgeWait float64
+
+	// perfectDodge is set once the current dodge has been rewarded as perfect, and flash is how long is left of the
+	// tint shown for it
+	perfectDodge bool
+	flash        float64
+
+	// roll is how far the body has rotated into the current dodge
+	roll, prevRoll float64
+
+	// dust is kicked up by each footstep
+	dust    []dustPuff
+	dustImd *imdraw.IMDraw
 }
 
-func (gp *body) init() {
-	if gp.sheet == nil || gp.anims == nil {
-		var err error
+type dustPuff struct {
+	pos pixel.Vec
+	age float64
+}
 
-		gp.sheet, gp.anims, err = loadAnimationSheet("spike", 104, filepath.Join("images", "sprites"))
+func (gp *body) init() {
+	if gp.sheet == nil {
+		sheet, anims, err := assets.sheet(spikeSheet)
 
 		if err != nil {
 			panic(err)
 		}
+
+		gp.sheet = sheet
+		gp.initAnimator(anims)
 	}
 
 	if gp.armSprite == nil {
-		im, err := loadPicture("images/sprites/arm")
+		im, err := assets.picture(armPicture)
 
 		if err != nil {
 			panic(err)
@@ -82,17 +128,67 @@ func (gp *body) init() {
 
 	gp.maxHealth = 100
 	gp.health = gp.maxHealth
+	gp.prevRect = gp.rect
 }
 
-func (gp *body) update(dt float64) {
+// initAnimator sets up the body's animation states. dying takes priority over dodging, which takes priority over
+// shooting, which takes priority over running.
+func (gp *body) initAnimator(anims map[string]*animation) {
+	gp.animator = newAnimator(anims)
 
-	if win.JustPressed(pixelgl.MouseButtonRight) && time.Now().Sub(gp.lastDodge) > time.Millisecond*600 && !isDodging {
-		isDodging = true
-		gp.dodgeEnd = time.Tick(time.Millisecond * 300)
+	gp.animator.addState(idle, "Front")
+
+	gp.animator.addState(running, "Run").update = func(dt float64) {
+		gp.runFrame = gp.animator.index()
+	}
+
+	shoot := gp.animator.addState(shooting, "Run")
+	shoot.frame = func(a *animation, t float64) pixel.Rect {
+		return a.frames[1]
+	}
+	shoot.update = func(dt float64) {
+		gp.shootInitialised--
 	}
 
-	// control the body with keys
-	if !isDodging {
+	gp.animator.addState(dying, "Die")
+	gp.animator.addState(dodging, "Dodge")
+
+	gp.animator.on("footstep", gp.kickDust)
+
+	gp.animator.addTransition("", dying, func() bool { return gp.health <= 0 })
+	gp.animator.addTransition("", dodging, gp.dodging)
+	gp.animator.addTransition("", shooting, func() bool { return gp.shootInitialised > 0 })
+	gp.animator.addTransition("", running, func() bool { return gp.vel.Len() > 0 })
+	gp.animator.addTransition("", idle, func() bool { return gp.vel.Len() == 0 })
+
+	gp.animator.enter(idle)
+}
+
+// reloadAssets swaps in the body's sheet and arm, after they've been reloaded.
+func (gp *body) reloadAssets() {
+	if s, ok := assets.current(spikeSheet).(*animationSheet); ok {
+		gp.sheet = s.sheet
+		gp.animator.setAnimations(s.anims)
+		gp.imd = imdraw.New(gp.sheet)
+	}
+
+	if im, ok := assets.current(armPicture).(pixel.Picture); ok {
+		gp.armSprite = pixel.NewSprite(im, im.Bounds())
+	}
+}
+
+func (gp *body) destroy() {
+	assets.release(spikeSheet)
+	assets.release(armPicture)
+}
+
+func (gp *body) update(dt float64) {
+	gp.prevRect = gp.rect
+
+	gp.updateDodge(dt)
+
+	// control the body with keys. the direction can't be changed during a dodge.
+	if !gp.dodging() {
 		gp.ctrl = pixel.ZV
 
 		if gp.health > 0 {
@@ -111,16 +207,10 @@ func (gp *body) update(dt float64) {
 		}
 	}
 
-	select {
-	case <-gp.dodgeEnd:
-		isDodging = false
-		gp.lastDodge = time.Now()
-		gp.dodgeEnd = nil
-	default:
+	if justPressed(pixelgl.MouseButtonRight) && gp.canDodge() {
+		gp.dodge()
 	}
 
-	dodgeMultiplier := 1.0
-
 	// apply controls
 	switch {
 	case gp.ctrl.X < 0:
@@ -140,88 +230,165 @@ func (gp *body) update(dt float64) {
 		gp.vel.Y = 0
 	}
 
-	if isDodging {
-		dodgeMultiplier = 2
+	if gp.dodging() {
+		gp.vel = gp.vel.Scaled(dodgeSpeed)
 	}
 
-	gp.vel = gp.vel.Scaled(dt).Scaled(dodgeMultiplier)
-
 	// apply gravity and velocity
-	gp.rect = gp.rect.Moved(gp.vel)
+	gp.rect = gp.rect.Moved(gp.vel.Scaled(dt))
 
-	gp.counter += dt
+	if justPressed(pixelgl.MouseButtonLeft) && gp.animator.state() != running {
+		gp.shootInitialised = 10
+	}
 
-	// determine the new animation state
-	var newState gopherAnimationState
-	switch {
-	case gp.vel.Len() == 0:
-		newState = idle
-	case gp.vel.Len() > 0:
-		newState = running
+	gp.animator.update(dt)
+
+	gp.frame = gp.animator.frame()
+
+	// set the facing direction of the body
+	if gp.vel.X != 0 {
+		if gp.vel.X > 0 {
+			gp.dir = -1
+		} else {
+			gp.dir = +1
+		}
 	}
 
-	if win.JustPressed(pixelgl.MouseButtonLeft) && gp.state != running {
-		newState = shooting
-		gp.shootInitialised = 10
+	if ded {
+		gp.h += 7.2 * dt
+
+		if gp.h >= 0.5 {
+			gp.h = 0.8
+		}
+	} else {
+		gp.h = gp.health / gp.maxHealth
 	}
 
-	if gp.shootInitialised > 0 {
-		newState = shooting
-		gp.shootInitialised--
+	gp.updateDust(dt)
+	gp.updateArm(dt)
+}
+
+// updateDodge counts down the current dodge, the cooldown before the next one and the perfect dodge flash.
+func (gp *body) updateDodge(dt float64) {
+	gp.flash = math.Max(0, gp.flash-dt)
+	gp.prevRoll = gp.roll
+
+	if !gp.dodging() {
+		gp.dodgeWait = math.Max(0, gp.dodgeWait-dt)
+		return
 	}
 
-	if gp.health <= 0 {
-		newState = dying
+	gp.dodgeTime -= dt
+
+	if gp.dodgeTime <= 0 {
+		gp.dodgeTime = 0
+		gp.dodgeWait = dodgeCooldown
+		gp.roll, gp.prevRoll = 0, 0
+
+		return
 	}
 
-	// reset the time counter if the state changed
-	if gp.state != newState {
-		gp.state = newState
-		gp.counter = 0
+	// roll forwards, in the direction the body is facing
+	gp.roll = 2 * math.Pi * (1 - gp.dodgeTime/dodgeDuration) * gp.dir
+}
+
+func (gp *body) dodging() bool {
+	return gp.dodgeTime > 0
+}
+
+func (gp *body) canDodge() bool {
+	return !gp.dodging() && gp.dodgeWait <= 0 && gp.health > 0 && !ded
+}
+
+// dodgeAge is how long it's been since the current dodge started.
+func (gp *body) dodgeAge() float64 {
+	return dodgeDuration - gp.dodgeTime
+}
+
+// invulnerable reports whether the body can't be hurt, for the first part of a dodge.
+func (gp *body) invulnerable() bool {
+	return gp.dodging() && gp.dodgeAge() < dodgeInvulnerable
+}
+
+// dodge starts a dodge roll in the direction the body is being moved, or the way it's facing if it isn't moving. a
+// dodge on the beat is perfect.
+func (gp *body) dodge() {
+	gp.dodgeTime = dodgeDuration
+	gp.perfectDodge = false
+
+	if gp.ctrl == pixel.ZV {
+		gp.ctrl.X = -gp.dir
 	}
 
-	if !isDodging {
+	if playerScore.judgeDodge() {
+		gp.rewardDodge()
+	}
+}
 
-		// determine the correct animation frame
-		switch gp.state {
-		case idle:
-			i := int(math.Floor(gp.counter / gp.rate / 2))
-			gp.frame = gp.anims["Front"][i%len(gp.anims["Front"])]
-		case running:
-			gp.runFrame = int(math.Floor(gp.counter/gp.rate)) % len(gp.anims["Run"])
-			gp.frame = gp.anims["Run"][gp.runFrame]
-		case shooting:
-			gp.frame = gp.anims["Run"][1]
-		case dying:
-			i := int(math.Floor(gp.counter / gp.rate))
+// dodged is called when an attack misses because the body is invulnerable. it's a perfect dodge if the dodge only
+// just started.
+func (gp *body) dodged() {
+	if gp.dodgeAge() <= perfectDodgeWindow {
+		gp.rewardDodge()
+	}
+}
 
-			if i >= len(gp.anims["Die"])-1 {
-				gp.frame = gp.anims["Die"][len(gp.anims["Die"])-1]
-			} else {
-				gp.frame = gp.anims["Die"][i]
-			}
-		case jumping:
-			speed := gp.vel.Y
-			i := int((-speed/gp.jumpSpeed + 1) / 2 * float64(len(gp.anims["Jump"])))
-			if i < 0 {
-				i = 0
-			}
-			if i >= len(gp.anims["Front"]) {
-				i = len(gp.anims["Front"]) - 1
-			}
-			gp.frame = gp.anims["Front"][i]
-		}
+// rewardDodge rewards a perfect dodge, once per dodge.
+func (gp *body) rewardDodge() {
+	if gp.perfectDodge {
+		return
 	}
-	// set the facing direction of the body
-	if gp.vel.X != 0 {
-		if gp.vel.X > 0 {
-			gp.dir = -1
-		} else {
-			gp.dir = +1
+
+	gp.perfectDodge = true
+	gp.flash = perfectDodgeFlash
+
+	if characterIsOutside {
+		playerScore.progress(perfectDodgeProgress)
+		playerScore.incrementScore(perfectDodgeScore)
+	}
+}
+
+// kickDust kicks up a puff of dust behind the body's feet.
+func (gp *body) kickDust() {
+	r := gp.rect.Norm()
+
+	gp.dust = append(gp.dust, dustPuff{
+		pos: pixel.V(r.Center().X+gp.dir*r.W()/4, r.Min.Y),
+	})
+}
+
+func (gp *body) updateDust(dt float64) {
+	for i := len(gp.dust) - 1; i >= 0; i-- {
+		gp.dust[i].age += dt
+
+		if gp.dust[i].age >= dustLifetime {
+			gp.dust = append(gp.dust[:i], gp.dust[i+1:]...)
 		}
 	}
+}
+
+func (gp *body) drawDust(t pixel.Target) {
+	if gp.dustImd == nil {
+		gp.dustImd = imdraw.New(nil)
+	}
 
-	gp.updateArm(dt)
+	gp.dustImd.Clear()
+
+	for _, puff := range gp.dust {
+		// the dust grows and fades as it settles
+		life := math.Min(1, (puff.age+simulationStep*frameAlpha)/dustLifetime)
+
+		gp.dustImd.Color = pixel.RGB(0.5, 0.5, 0.5).Mul(pixel.Alpha(0.6 * (1 - life)))
+		gp.dustImd.Push(puff.pos.Add(pixel.V(0, dustRadius*life)))
+		gp.dustImd.Circle(dustRadius*(0.4+0.6*life), 0)
+	}
+
+	gp.dustImd.Draw(t)
+}
+
+// interpolatedRect is where the body is drawn, between its last two positions.
+func (gp *body) interpolatedRect() pixel.Rect {
+	return lerpRect(gp.prevRect, gp.rect, frameAlpha)
 }
 
 // bobbing is a map of how much the arm/body move up by when running
@@ -289,35 +456,36 @@ func (gp *body) draw(t pixel.Target) {
 
 	gp.imd.Clear()
 
-	if ded {
-		gp.h += 0.05
+	rect := gp.interpolatedRect()
 
-		if gp.h >= 0.5 {
-			gp.h = 0.8
-		}
-	} else {
-		gp.h = gp.health / gp.maxHealth
-	}
+	gp.drawDust(t)
 
-	if gp.state != idle && gp.state != dying {
-		// only draw the arm if we're not idling
-		gp.armSprite.DrawColorMask(t, gp.armMatrix, pixel.RGB(gp.h, gp.h, gp.h))
+	if state := gp.animator.state(); state != idle && state != dying && state != dodging {
+		// only draw the arm if we're not idling or rolling
+		gp.armSprite.DrawColorMask(t, gp.armMatrix.Moved(rect.Center().Sub(gp.rect.Center())), pixel.RGB(gp.h, gp.h, gp.h))
 	}
 
 	if gp.sprite == nil {
 		gp.sprite = pixel.NewSprite(nil, pixel.Rect{})
 	}
 
-	// draw the correct frame with the correct position and direction
+	mask := pixel.RGB(gp.h, gp.h, gp.h)
+
+	if gp.flash > 0 {
+		mask = pixel.ToRGBA(playerScore.color)
+	}
+
+	// draw the correct frame with the correct position, direction and roll
 	gp.sprite.Set(gp.sheet, gp.frame)
 	gp.sprite.DrawColorMask(gp.imd, pixel.IM.
 		ScaledXY(pixel.ZV, pixel.V(
-			gp.rect.W()/gp.sprite.Frame().W(),
-			gp.rect.H()/gp.sprite.Frame().H(),
+			rect.W()/gp.sprite.Frame().W(),
+			rect.H()/gp.sprite.Frame().H(),
 		)).
 		ScaledXY(pixel.ZV, pixel.V(-gp.dir, 1)).
-		Moved(gp.rect.Center()),
-		pixel.RGB(gp.h, gp.h, gp.h),
+		Rotated(pixel.ZV, gp.prevRoll+(gp.roll-gp.prevRoll)*frameAlpha).
+		Moved(rect.Center()),
+		mask,
 	)
 	gp.imd.Draw(t)
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"image/color"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+)
+
+// boltRadius is the size of an enemy's bolt
+const boltRadius = 8
+
+// bolt is fired at the player by ranged enemies
+type bolt struct {
+	pos, prevPos pixel.Vec
+	vel          pixel.Vec
+	damage       float64
+	color        color.Color
+
+	done bool
+}
+
+func (b *bolt) init() {
+	b.prevPos = b.pos
+
+	registerCollidable(b)
+}
+
+func (b *bolt) destroy() {
+	b.done = true
+
+	deregisterCollidable(b)
+}
+
+func (b *bolt) HandleCollision(x Collidable, collisionTime float64, normal pixel.Vec) {
+	// bolts fly through a dodging character
+	if c, ok := x.(*character); ok && c.body.invulnerable() {
+		return
+	}
+
+	b.destroy()
+}
+
+func (b *bolt) Layer() collisionLayer {
+	return layerEnemyProjectile
+}
+
+func (b *bolt) Mask() collisionLayer {
+	return layerStatic | layerTrigger | layerPlayer
+}
+
+// Vel is the distance moved by the bolt in the last simulation step.
+func (b *bolt) Vel() pixel.Vec {
+	return b.vel.Scaled(simulationStep)
+}
+
+func (b *bolt) Rect() pixel.Rect {
+	return pixel.R(b.pos.X-boltRadius, b.pos.Y-boltRadius, b.pos.X+boltRadius, b.pos.Y+boltRadius)
+}
+
+func (b *bolt) update(dt float64) {
+	b.prevPos = b.pos
+	b.pos = b.pos.Add(b.vel.Scaled(dt))
+
+	// bolts which miss everything fly off the street
+	if !streetBoundingRect.Norm().Contains(b.pos) {
+		b.destroy()
+	}
+}
+
+func (b *bolt) draw(imd *imdraw.IMDraw) {
+	imd.Color = b.color
+	imd.Push(pixel.Lerp(b.prevPos, b.pos, frameAlpha))
+	imd.Circle(boltRadius, 0)
+}
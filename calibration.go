@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/speaker"
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+	"golang.org/x/image/colornames"
+	"golang.org/x/image/font/basicfont"
+)
+
+const (
+	metronomeBPM        = 120
+	metronomeSampleRate = beep.SampleRate(44100)
+
+	// calibrationLeadIn is the number of beats to ignore taps for, so the player can find the rhythm
+	calibrationLeadIn = 4
+
+	// calibrationTaps is the number of taps to record for each stage
+	calibrationTaps = 16
+)
+
+type calibrationStage int
+
+const (
+	calibrateAudio calibrationStage = iota
+	calibrateVisual
+	calibrationResults
+)
+
+// calibration is a scene which plays a metronome and records the player's taps against it, first listening to
+// it and then watching it, to work out how much their audio and display are delayed by.
+type calibration struct {
+	stage calibrationStage
+
+	clock beatClock
+
+	// offsets are the tapped offsets from the beat for each stage
+	offsets         [calibrationResults][]time.Duration
+	mean, deviation [calibrationResults]time.Duration
+
+	done bool
+
+	flash                   *imdraw.IMDraw
+	atlas                   *text.Atlas
+	instructions, resultMsg string
+}
+
+func (c *calibration) init() {
+	c.atlas = text.NewAtlas(
+		basicfont.Face7x13,
+		text.ASCII,
+	)
+
+	c.flash = imdraw.New(nil)
+
+	c.start(calibrateAudio)
+}
+
+// start begins a stage of the calibration, restarting the metronome.
+func (c *calibration) start(stage calibrationStage) {
+	c.stage = stage
+
+	switch stage {
+	case calibrateAudio:
+		c.instructions = "Click or press space on each beat you hear"
+	case calibrateVisual:
+		c.instructions = "Click or press space on each flash you see"
+	case calibrationResults:
+		speaker.Clear()
+		c.results()
+		return
+	}
+
+	b := &beatmap{
+		Audio: "metronome",
+		Tempo: []tempoChange{{Beat: 0, BPM: metronomeBPM}},
+	}
+
+	if err := b.init(); err != nil {
+		panic(err)
+	}
+
+	m := &metronome{
+		sampleRate: metronomeSampleRate,
+		bpm:        metronomeBPM,
+		silent:     stage == calibrateVisual,
+	}
+
+	latency, err := initSpeaker(metronomeSampleRate)
+
+	if err != nil {
+		panic(err)
+	}
+
+	c.clock.reset(m, metronomeSampleRate, b)
+	c.clock.setLatency(latency)
+
+	speaker.Play(&c.clock)
+}
+
+func (c *calibration) update() {
+	if win.JustPressed(pixelgl.KeyEscape) {
+		speaker.Clear()
+		c.done = true
+		return
+	}
+
+	if c.stage == calibrationResults {
+		if win.JustPressed(pixelgl.KeyEnter) {
+			userSettings.AudioOffsetMS = float64(c.mean[calibrateAudio]) / float64(time.Millisecond)
+			userSettings.VisualOffsetMS = float64(c.mean[calibrateVisual]) / float64(time.Millisecond)
+
+			if err := userSettings.save(); err != nil {
+				panic(err)
+			}
+
+			c.done = true
+		}
+
+		return
+	}
+
+	if !win.JustPressed(pixelgl.MouseButtonLeft) && !win.JustPressed(pixelgl.KeySpace) {
+		return
+	}
+
+	if c.clock.beat() < calibrationLeadIn-0.5 {
+		return
+	}
+
+	c.offsets[c.stage] = append(c.offsets[c.stage], c.clock.beatOffset())
+
+	if len(c.offsets[c.stage]) >= calibrationTaps {
+		c.start(c.stage + 1)
+	}
+}
+
+// results calculates the mean and standard deviation of the offsets for each stage.
+func (c *calibration) results() {
+	for stage := calibrateAudio; stage < calibrationResults; stage++ {
+		offsets := c.offsets[stage]
+
+		var mean, variance float64
+
+		for _, offset := range offsets {
+			mean += float64(offset)
+		}
+
+		mean /= float64(len(offsets))
+
+		for _, offset := range offsets {
+			variance += math.Pow(float64(offset)-mean, 2)
+		}
+
+		variance /= float64(len(offsets))
+
+		c.mean[stage] = time.Duration(mean)
+		c.deviation[stage] = time.Duration(math.Sqrt(variance))
+	}
+
+	c.instructions = "Press enter to save, or escape to cancel"
+	c.resultMsg = fmt.Sprintf(
+		"Audio offset:  %+dms (+/- %dms)\nVisual offset: %+dms (+/- %dms)",
+		c.mean[calibrateAudio].Milliseconds(), c.deviation[calibrateAudio].Milliseconds(),
+		c.mean[calibrateVisual].Milliseconds(), c.deviation[calibrateVisual].Milliseconds(),
+	)
+}
+
+func (c *calibration) draw(canvas *pixelgl.Canvas) {
+	canvas.Clear(colornames.Black)
+
+	// flash the screen on each beat for the visual stage
+	if c.stage == calibrateVisual && c.clock.beat() >= 0 && c.clock.phase() < 0.1 {
+		c.flash.Clear()
+		c.flash.Color = colornames.White
+		c.flash.Push(camPos.Sub(pixel.V(100, 100)), camPos.Add(pixel.V(100, 100)))
+		c.flash.Rectangle(0)
+		c.flash.Draw(canvas)
+	}
+
+	tx := text.New(camPos.Add(pixel.V(-200, 150)), c.atlas)
+	tx.Color = colornames.White
+
+	fmt.Fprintln(tx, "Calibration")
+	fmt.Fprintln(tx)
+	fmt.Fprintln(tx, c.instructions)
+
+	if c.stage == calibrationResults {
+		fmt.Fprintln(tx)
+		fmt.Fprintln(tx, c.resultMsg)
+	} else {
+		fmt.Fprintf(tx, "\n%d/%d\n", len(c.offsets[c.stage]), calibrationTaps)
+	}
+
+	tx.Draw(canvas, pixel.IM)
+}
+
+// metronome generates a click on every beat, with a higher click on the first beat of each bar.
+type metronome struct {
+	sampleRate beep.SampleRate
+	bpm        float64
+	silent     bool
+
+	pos int
+}
+
+func (m *metronome) Stream(samples [][2]float64) (n int, ok bool) {
+	period := m.sampleRate.N(time.Duration(float64(time.Minute) / m.bpm))
+	click := m.sampleRate.N(time.Millisecond * 30)
+
+	for i := range samples {
+		var v float64
+
+		if p := m.pos % period; p < click && !m.silent {
+			pitch := 1000.0
+
+			if (m.pos/period)%4 == 0 {
+				pitch = 1500
+			}
+
+			t := float64(p) / float64(m.sampleRate)
+			v = math.Sin(2*math.Pi*pitch*t) * (1 - float64(p)/float64(click)) * 0.5
+		}
+
+		samples[i] = [2]float64{v, v}
+		m.pos++
+	}
+
+	return len(samples), true
+}
+
+func (m *metronome) Err() error {
+	return nil
+}
+
+func (m *metronome) Len() int {
+	return math.MaxInt32
+}
+
+func (m *metronome) Position() int {
+	return m.pos
+}
+
+func (m *metronome) Seek(p int) error {
+	m.pos = p
+	return nil
+}
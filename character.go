@@ -2,7 +2,6 @@ package main
 
 import (
 	"image/color"
-	"time"
 
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/imdraw"
@@ -13,55 +12,66 @@ var characterIsOutside = false
 type character struct {
 	body   *body
 	weapon *weapon
-
-	tick <-chan time.Time
 }
 
+// Vel is the distance moved by the character in the last simulation step.
 func (c *character) Vel() pixel.Vec {
-	return c.body.vel
+	return c.body.vel.Scaled(simulationStep)
 }
 
 func (c *character) HandleCollision(x Collidable, collisionTime float64, normal pixel.Vec) {
-	switch collidable := x.(type) {
-	case *wall:
+	switch {
+	case x.Layer()&layerEnemyProjectile != 0:
+		if c.body.invulnerable() {
+			c.body.dodged()
+			return
+		}
+
+		if b, ok := x.(*bolt); ok {
+			c.hurt(b.damage)
+		}
+	default:
+		// move back to where the character hit the surface
+		back := c.Vel().Scaled(1 - collisionTime)
+
 		if normal.Y == 0 {
-			// collision in X. move back by c.body.vel (with a negated x)
-			c.body.rect = c.body.rect.Moved(c.body.vel.ScaledXY(pixel.V(-1, 0)))
+			// collision in X. move back by back (with a negated x)
+			c.body.rect = c.body.rect.Moved(back.ScaledXY(pixel.V(-1, 0)))
 		} else {
-			// collision in Y. move back by c.body.vel (with a negated y)
-			c.body.rect = c.body.rect.Moved(c.body.vel.ScaledXY(pixel.V(0, -1)))
+			// collision in Y. move back by back (with a negated y)
+			c.body.rect = c.body.rect.Moved(back.ScaledXY(pixel.V(0, -1)))
 		}
-	case *enemy:
-		// damage
-
-		select {
-		case <-c.tick:
-			if !collidable.isAttacking {
-				return
-			}
+	}
+}
 
-			if playerScore.multiplier > 1 {
-				playerScore.setMultiplier(playerScore.multiplier - 1)
-				c.body.health -= 5
+// hurt takes damage from the character. while they have a multiplier, it's lost instead of most of the damage. they
+// can't be hurt at the start of a dodge.
+func (c *character) hurt(damage float64) {
+	if ded || c.body.invulnerable() {
+		return
+	}
 
-				playerScore.incrementScore(-20.0)
+	if playerScore.multiplier > 1 {
+		playerScore.setMultiplier(playerScore.multiplier - 1)
+		c.body.health -= damage / 4
+	} else {
+		c.body.health -= damage
+	}
 
-				if c.body.health <= 0 {
-					c.die()
-				}
-			} else {
-				c.body.health -= 20
+	playerScore.incrementScore(-20.0)
 
-				playerScore.incrementScore(-20.0)
+	if c.body.health <= 0 {
+		c.die()
+	}
+}
 
-				if c.body.health <= 0 {
-					c.die()
-				}
-			}
-		default:
+func (c *character) Layer() collisionLayer {
+	return layerPlayer
+}
 
-		}
-	}
+func (c *character) Mask() collisionLayer {
+	// enemies only hurt with their scythes, when the blade passes through the character
+	return layerStatic | layerEnemyProjectile
 }
 
 func (c *character) Rect() pixel.Rect {
@@ -70,8 +80,8 @@ func (c *character) Rect() pixel.Rect {
 
 func (c *character) die() {
 	ded = true
-	isDodging = false
-	go playerScore.changeTrack(nightOnTheDocksAudio)
+	c.body.dodgeTime = 0
+	go playerScore.changeTrack(tracks[deathTrack])
 }
 
 func (c *character) init() {
@@ -83,20 +93,22 @@ func (c *character) init() {
 		runSpeed:  300,
 		jumpSpeed: 192,
 		rect:      pixel.R(-62, -74, 62, 74).Moved(playerSpawnPos),
-		rate:      1.0 / 10,
 		dir:       -1,
 	}
 	c.body.init()
 
 	c.weapon = handgun
 	c.weapon.init()
+}
 
-	c.tick = time.Tick(time.Millisecond * 200)
+func (c *character) destroy() {
+	deregisterCollidable(c)
+	c.body.destroy()
 }
 
 func (c *character) update(dt float64) {
 	c.body.update(dt)
-	c.weapon.update(dt, c.body.shootPos, c.body.vel)
+	c.weapon.update(dt, c.body.shootPos, c.body.vel, c.body.dodging())
 
 	characterIsOutside = c.body.rect.Norm().Intersect(streetBoundingRect.Norm()).Area() > 0
 	/*
@@ -2,6 +2,7 @@ package main
 
 import (
 	"math"
+	"sort"
 
 	"github.com/faiface/pixel"
 )
@@ -10,27 +11,178 @@ type Collidable interface {
 	Rect() pixel.Rect
 	Vel() pixel.Vec
 	HandleCollision(obj Collidable, collisionTime float64, normal pixel.Vec)
+
+	// Layer is the layer (or layers) the collidable is on
+	Layer() collisionLayer
+
+	// Mask is the layers the collidable handles collisions with
+	Mask() collisionLayer
+}
+
+// collisionLayer is a bitmask of the kinds of collidable. two collidables are only checked for a collision if either
+// of their masks contains the other's layer, and a collision is only handled by a collidable whose mask contains
+// the other's layer.
+type collisionLayer uint
+
+const (
+	layerPlayer collisionLayer = 1 << iota
+	layerEnemy
+	layerPlayerProjectile
+	layerEnemyProjectile
+
+	// layerStatic is the static world, which never moves. static collidables are never checked against each other.
+	layerStatic
+
+	// layerTrigger is for areas which something may react to, but don't block anything by themselves
+	layerTrigger
+)
+
+func isStatic(c Collidable) bool {
+	return c.Layer()&layerStatic != 0
 }
 
-var collidables = make(map[Collidable]bool)
+// collidables maps every registered collidable to when it was registered. collisions are always checked and resolved
+// in registration order, so that the outcome of a step doesn't depend on map iteration order.
+var collidables = make(map[Collidable]uint64)
+
+var nextCollidableID uint64
+
+// collisionCellSize is the size of the cells in the spatial hashes used to find possible collisions
+const collisionCellSize = 256
+
+var (
+	// staticHash holds every static collidable, and is only rebuilt when they change
+	staticHash        = newSpatialHash(collisionCellSize)
+	staticHashChanged = true
+
+	// dynamicHash holds every moving collidable, and is rebuilt every step
+	dynamicHash = newSpatialHash(collisionCellSize)
+)
 
 func deregisterCollidable(c Collidable) {
 	delete(collidables, c)
+
+	if isStatic(c) {
+		staticHashChanged = true
+	}
+
+	if blocksNavigation(c) {
+		navGridChanged = true
+	}
 }
 
 func registerCollidable(c Collidable) {
-	collidables[c] = true
+	if _, ok := collidables[c]; ok {
+		return
+	}
+
+	collidables[c] = nextCollidableID
+	nextCollidableID++
+
+	if isStatic(c) {
+		staticHashChanged = true
+	}
+
+	if blocksNavigation(c) {
+		navGridChanged = true
+	}
 }
 
+func isRegistered(c Collidable) bool {
+	_, ok := collidables[c]
+
+	return ok
+}
+
+// orderedCollidables returns every registered collidable in the order they were registered.
+func orderedCollidables() []Collidable {
+	ordered := make([]Collidable, 0, len(collidables))
+
+	for c := range collidables {
+		ordered = append(ordered, c)
+	}
+
+	sort.Slice(ordered, func(i, j int) bool {
+		return collidables[ordered[i]] < collidables[ordered[j]]
+	})
+
+	return ordered
+}
+
+// checkAllCollisions finds and handles every collision between registered collidables. collidables are checked
+// after they have moved, by sweeping them back over the distance they moved in the last step.
+func checkAllCollisions() {
+	ordered := orderedCollidables()
+
+	if staticHashChanged {
+		staticHash.clear()
+
+		for _, c := range ordered {
+			if isStatic(c) {
+				staticHash.insert(c, c.Rect())
+			}
+		}
+
+		staticHashChanged = false
+	}
+
+	dynamicHash.clear()
+
+	for _, c := range ordered {
+		if !isStatic(c) {
+			dynamicHash.insert(c, sweptBroadphaseRect(c))
+		}
+	}
+
+	// static collidables never collide with each other, so every pair has at least one moving collidable in it
+	for _, c := range ordered {
+		if !isStatic(c) {
+			checkCollisions(c)
+		}
+	}
+}
+
+// checkCollisions checks a moving collidable against every static collidable, and every moving collidable
+// registered after it, so that each pair is only checked once.
 func checkCollisions(c Collidable) {
-	for x := range collidables {
-		bpb := sweptBroadphaseRect(c)
+	bpb := sweptBroadphaseRect(c)
+
+	check := func(x Collidable) {
+		checkPair(c, x, bpb)
+	}
 
-		if aabbCheck(bpb, x.Rect()) {
-			collisionTime, normal := sweptAABB(c, x)
+	staticHash.query(bpb, check)
+	dynamicHash.query(bpb, check)
+}
 
-			if collisionTime < 1 {
-				x.HandleCollision(c, collisionTime, normal)
+// checkPair checks a moving collidable c, whose broadphase rect is bpb, against x, and handles the collision if they
+// hit. pairs are skipped if x is a moving collidable registered before c, as that pair has already been checked.
+func checkPair(c, x Collidable, bpb pixel.Rect) {
+	// either collidable may have been removed by handling an earlier collision
+	if !isRegistered(c) || !isRegistered(x) {
+		return
+	}
+
+	if !isStatic(x) && collidables[x] <= collidables[c] {
+		return
+	}
+
+	cHandles := c.Mask()&x.Layer() != 0
+	xHandles := x.Mask()&c.Layer() != 0
+
+	if !cHandles && !xHandles {
+		return
+	}
+
+	if aabbCheck(bpb, sweptBroadphaseRect(x)) {
+		collisionTime, normal := sweptPair(c, x)
+
+		if collisionTime < 1 {
+			if xHandles {
+				x.HandleCollision(c, collisionTime, normal.Scaled(-1))
+			}
+
+			if cHandles {
 				c.HandleCollision(x, collisionTime, normal)
 			}
 		}
@@ -46,43 +198,37 @@ func aabbCheck(b1, b2 pixel.Rect) bool {
 }
 
 // sweptBroadphaseRect calculates the broadphase area for a collidable. This creates a collision box which has been scaled
-// up to include where the box has travelled under its velocity.
+// up to include where the box has travelled from under its velocity during the last step.
 func sweptBroadphaseRect(b Collidable) pixel.Rect {
-	r := pixel.Rect{}
 	bRect := b.Rect().Norm()
 
-	if b.Vel().X > 0 {
-		r.Min.X = bRect.Min.X
-		r.Max.X = bRect.Max.X + b.Vel().X
-	} else {
-		r.Min.X = bRect.Min.X + b.Vel().X
-		r.Max.X = bRect.Max.X - b.Vel().X
-	}
+	return bRect.Union(bRect.Moved(b.Vel().Scaled(-1)))
+}
 
-	if b.Vel().Y > 0 {
-		r.Min.Y = bRect.Min.Y
-		r.Max.Y = bRect.Max.Y + b.Vel().Y
-	} else {
-		r.Min.Y = bRect.Min.Y + b.Vel().Y
-		r.Max.Y = bRect.Max.Y - b.Vel().Y
-	}
+// sweptPair sweeps two collidables from where they were at the start of the last step, using the velocity of a
+// relative to b. the normal returned is of the surface of b which a hit.
+func sweptPair(a, b Collidable) (collisionTime float64, normal pixel.Vec) {
+	aStart := a.Rect().Moved(a.Vel().Scaled(-1))
+	bStart := b.Rect().Moved(b.Vel().Scaled(-1))
+
+	collisionTime, normal = sweptAABB(aStart, a.Vel().Sub(b.Vel()), bStart)
 
-	return r
+	return math.Max(collisionTime, 0), normal
 }
 
-// sweptAABB checks for a collision between a moving AABB and a static AABB,
+// sweptAABB checks for a collision between an AABB moving by vel and a static AABB,
 // returning:
 // - the collisionTime: a value between 0 and 1 means a collision occurred
 // - the normal of the collided surface
 //
 // ref: https://www.gamedev.net/articles/programming/general-and-gameplay-programming/swept-aabb-collision-detection-and-response-r3084/
-func sweptAABB(moving, static Collidable) (collisionTime float64, normal pixel.Vec) {
-	movingRect := moving.Rect().Norm()
-	staticRect := static.Rect().Norm()
+func sweptAABB(movingRect pixel.Rect, vel pixel.Vec, staticRect pixel.Rect) (collisionTime float64, normal pixel.Vec) {
+	movingRect = movingRect.Norm()
+	staticRect = staticRect.Norm()
 
 	var entryInv, exitInv pixel.Vec
 
-	if moving.Vel().X > 0 {
+	if vel.X > 0 {
 		entryInv.X = staticRect.Min.X - (movingRect.Min.X + movingRect.W())
 		exitInv.X = (staticRect.Min.X + staticRect.W()) - movingRect.Min.X
 	} else {
@@ -90,7 +236,7 @@ func sweptAABB(moving, static Collidable) (collisionTime float64, normal pixel.V
 		exitInv.X = staticRect.Min.X - (movingRect.Min.X + movingRect.W())
 	}
 
-	if moving.Vel().Y > 0 {
+	if vel.Y > 0 {
 		entryInv.Y = staticRect.Min.Y - (movingRect.Min.Y + movingRect.H())
 		exitInv.Y = (staticRect.Min.Y + staticRect.H()) - movingRect.Min.Y
 	} else {
@@ -100,20 +246,20 @@ func sweptAABB(moving, static Collidable) (collisionTime float64, normal pixel.V
 
 	var entry, exit pixel.Vec
 
-	if moving.Vel().X == 0 {
+	if vel.X == 0 {
 		entry.X = math.Inf(-1)
 		exit.X = math.Inf(1)
 	} else {
-		entry.X = entryInv.X / moving.Vel().X
-		exit.X = exitInv.X / moving.Vel().X
+		entry.X = entryInv.X / vel.X
+		exit.X = exitInv.X / vel.X
 	}
 
-	if moving.Vel().Y == 0 {
+	if vel.Y == 0 {
 		entry.Y = math.Inf(-1)
 		exit.Y = math.Inf(1)
 	} else {
-		entry.Y = entryInv.Y / moving.Vel().Y
-		exit.Y = exitInv.Y / moving.Vel().Y
+		entry.Y = entryInv.Y / vel.Y
+		exit.Y = exitInv.Y / vel.Y
 	}
 
 	// earliest time of collision
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/faiface/pixel"
+)
+
+// testCollidable is a collidable which records the collisions it handles.
+type testCollidable struct {
+	rect        pixel.Rect
+	vel         pixel.Vec
+	layer, mask collisionLayer
+
+	hits []Collidable
+}
+
+func (c *testCollidable) Rect() pixel.Rect {
+	return c.rect
+}
+
+func (c *testCollidable) Vel() pixel.Vec {
+	return c.vel
+}
+
+func (c *testCollidable) HandleCollision(x Collidable, collisionTime float64, normal pixel.Vec) {
+	c.hits = append(c.hits, x)
+}
+
+func (c *testCollidable) Layer() collisionLayer {
+	return c.layer
+}
+
+func (c *testCollidable) Mask() collisionLayer {
+	return c.mask
+}
+
+// queryAll is every collidable query finds around r, in the order it finds them.
+func queryAll(h *spatialHash, r pixel.Rect) []Collidable {
+	var found []Collidable
+
+	h.query(r, func(c Collidable) {
+		found = append(found, c)
+	})
+
+	return found
+}
+
+func TestSpatialHashQueryDeduplicates(t *testing.T) {
+	h := newSpatialHash(10)
+
+	// covers four cells, all of which are queried
+	big := &testCollidable{rect: pixel.R(5, 5, 15, 15)}
+	small := &testCollidable{rect: pixel.R(1, 1, 2, 2)}
+
+	h.insert(big, big.rect)
+	h.insert(small, small.rect)
+
+	found := queryAll(h, pixel.R(0, 0, 20, 20))
+
+	if len(found) != 2 {
+		t.Fatalf("found %d collidables, want each of the 2 once", len(found))
+	}
+
+	if found[0] == found[1] {
+		t.Error("found the same collidable twice")
+	}
+}
+
+func TestSpatialHashNoStaleEntries(t *testing.T) {
+	h := newSpatialHash(10)
+	c := &testCollidable{rect: pixel.R(1, 1, 2, 2)}
+
+	h.insert(c, c.rect)
+
+	// the hash is cleared and rebuilt when collidables move or are removed
+	h.clear()
+	c.rect = c.rect.Moved(pixel.V(50, 50))
+	h.insert(c, c.rect)
+
+	if found := queryAll(h, pixel.R(0, 0, 5, 5)); len(found) != 0 {
+		t.Errorf("found %d collidables where c used to be, want none", len(found))
+	}
+
+	if found := queryAll(h, pixel.R(50, 50, 55, 55)); len(found) != 1 {
+		t.Errorf("found %d collidables where c moved to, want 1", len(found))
+	}
+
+	h.clear()
+
+	if found := queryAll(h, pixel.R(50, 50, 55, 55)); len(found) != 0 {
+		t.Errorf("found %d collidables after clearing, want none", len(found))
+	}
+}
+
+func TestCheckAllCollisionsRemovedCollidables(t *testing.T) {
+	resetCollisions()
+	defer resetCollisions()
+
+	w := &testCollidable{rect: pixel.R(0, 0, 100, 100), layer: layerStatic}
+	c := &testCollidable{rect: pixel.R(40, 40, 60, 60), vel: pixel.V(1, 0), layer: layerPlayer, mask: layerStatic}
+
+	registerCollidable(w)
+	registerCollidable(c)
+	checkAllCollisions()
+
+	if len(c.hits) != 1 {
+		t.Fatalf("handled %d collisions with the wall, want 1", len(c.hits))
+	}
+
+	deregisterCollidable(w)
+	checkAllCollisions()
+
+	if len(c.hits) != 1 {
+		t.Errorf("handled a collision with a removed wall")
+	}
+
+	// moving away from where it was hashed last step
+	registerCollidable(w)
+	c.rect = c.rect.Moved(pixel.V(500, 0))
+	checkAllCollisions()
+
+	if len(c.hits) != 1 {
+		t.Errorf("handled a collision with the wall after moving away from it")
+	}
+}
+
+func TestCheckAllCollisionsNeverPairsStatics(t *testing.T) {
+	resetCollisions()
+	defer resetCollisions()
+
+	a := &testCollidable{rect: pixel.R(0, 0, 100, 100), layer: layerStatic, mask: layerStatic}
+	b := &testCollidable{rect: pixel.R(50, 50, 150, 150), layer: layerStatic, mask: layerStatic}
+
+	registerCollidable(a)
+	registerCollidable(b)
+	checkAllCollisions()
+
+	if len(a.hits) != 0 || len(b.hits) != 0 {
+		t.Errorf("overlapping static collidables were paired %d times, want never", len(a.hits)+len(b.hits))
+	}
+}
+
+func TestCheckAllCollisionsAcrossCells(t *testing.T) {
+	resetCollisions()
+	defer resetCollisions()
+
+	// the wall is in the cells either side of x = collisionCellSize, and the collidable only in the right hand one
+	w := &testCollidable{rect: pixel.R(collisionCellSize-50, 0, collisionCellSize+10, 100), layer: layerStatic}
+	c := &testCollidable{
+		rect:  pixel.R(collisionCellSize+5, 40, collisionCellSize+25, 60),
+		vel:   pixel.V(-10, 0),
+		layer: layerPlayer,
+		mask:  layerStatic,
+	}
+
+	registerCollidable(w)
+	registerCollidable(c)
+	checkAllCollisions()
+
+	if len(c.hits) != 1 || c.hits[0] != w {
+		t.Errorf("handled %d collisions with a wall spanning two cells, want 1", len(c.hits))
+	}
+}
+
+func TestCheckAllCollisionsHandlesPairsOnce(t *testing.T) {
+	resetCollisions()
+	defer resetCollisions()
+
+	// three moving collidables on top of each other, which all handle collisions with each other
+	var cs []*testCollidable
+
+	for i := 0; i < 3; i++ {
+		c := &testCollidable{
+			rect:  pixel.R(0, 0, 50, 50).Moved(pixel.V(float64(i)*10, 0)),
+			vel:   pixel.V(float64(i), 1),
+			layer: layerEnemy,
+			mask:  layerEnemy,
+		}
+
+		registerCollidable(c)
+		cs = append(cs, c)
+	}
+
+	checkAllCollisions()
+
+	for i, c := range cs {
+		count := make(map[Collidable]int)
+
+		for _, x := range c.hits {
+			count[x]++
+		}
+
+		for j, other := range cs {
+			want := 1
+
+			if i == j {
+				want = 0
+			}
+
+			if count[other] != want {
+				t.Errorf("collidable %d handled %d collisions with %d, want %d", i, count[other], j, want)
+			}
+		}
+	}
+}
+
+func TestLaserMovesOutOfWall(t *testing.T) {
+	resetCollisions()
+	defer resetCollisions()
+
+	w := &wall{rect: pixel.R(100, -50, 200, 50)}
+	w.init()
+
+	l := &laser{pos: pixel.V(95, 0), velocity: pixel.V(2400, 0), thickness: 4}
+	l.init()
+	l.update(simulationStep)
+	checkAllCollisions()
+
+	if l.Rect().Norm().Intersect(w.rect).Area() > 0 {
+		t.Errorf("laser at %v was left inside the wall", l.pos)
+	}
+
+	if l.velocity.X >= 0 {
+		t.Errorf("laser velocity %v wasn't reflected by the wall", l.velocity)
+	}
+}
+
+// benchmarkLaserCounts are the numbers of lasers flying around the street in the collision benchmarks
+var benchmarkLaserCounts = []int{100, 250, 500}
+
+// BenchmarkCheckAllCollisions times a collision pass with the spatial hash broadphase.
+func BenchmarkCheckAllCollisions(b *testing.B) {
+	for _, n := range benchmarkLaserCounts {
+		b.Run(fmt.Sprintf("lasers=%d", n), func(b *testing.B) {
+			benchmarkCollisions(b, n, checkAllCollisions)
+		})
+	}
+}
+
+// BenchmarkCheckAllCollisionsNestedLoop times a collision pass which checks every moving collidable against every
+// other collidable, as it was done before the spatial hashes, for comparison.
+func BenchmarkCheckAllCollisionsNestedLoop(b *testing.B) {
+	for _, n := range benchmarkLaserCounts {
+		b.Run(fmt.Sprintf("lasers=%d", n), func(b *testing.B) {
+			benchmarkCollisions(b, n, checkAllCollisionsNestedLoop)
+		})
+	}
+}
+
+// checkAllCollisionsNestedLoop is checkAllCollisions without a broadphase.
+func checkAllCollisionsNestedLoop() {
+	ordered := orderedCollidables()
+
+	for _, c := range ordered {
+		if isStatic(c) {
+			continue
+		}
+
+		bpb := sweptBroadphaseRect(c)
+
+		for _, x := range ordered {
+			if x != c {
+				checkPair(c, x, bpb)
+			}
+		}
+	}
+}
+
+// benchmarkCollisions registers the walls of the level and n lasers flying around the street, then times check
+// while the lasers move.
+func benchmarkCollisions(b *testing.B, n int, check func()) {
+	l, err := loadLevel(gameFS, levelPath)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	resetCollisions()
+	defer resetCollisions()
+
+	for _, layer := range l.Layers {
+		for _, rect := range layer.Walls {
+			(&wall{rect: rect.rect()}).init()
+		}
+	}
+
+	street := l.Outside.rect().Norm()
+	rng := rand.New(rand.NewSource(1))
+
+	randomPos := func() pixel.Vec {
+		return pixel.V(
+			street.Min.X+rng.Float64()*street.W(),
+			street.Min.Y+rng.Float64()*street.H(),
+		)
+	}
+
+	lasers := make([]*laser, n)
+
+	for i := range lasers {
+		lasers[i] = &laser{
+			pos:       randomPos(),
+			velocity:  pixel.V(1000, 0).Rotated(rng.Float64() * 2 * math.Pi),
+			thickness: 10,
+		}
+		lasers[i].init()
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+
+		for _, l := range lasers {
+			l.update(simulationStep)
+			l.thickness = 10
+
+			// keep the lasers in the street, so the number being checked doesn't change
+			if !street.Contains(l.pos) {
+				l.pos = randomPos()
+				l.prevPos, l.lastVelocity = l.pos, pixel.ZV
+			}
+		}
+
+		b.StartTimer()
+
+		check()
+	}
+}
+
+// resetCollisions deregisters every collidable.
+func resetCollisions() {
+	collidables = make(map[Collidable]uint64)
+	staticHash = newSpatialHash(collisionCellSize)
+	staticHashChanged = true
+	dynamicHash = newSpatialHash(collisionCellSize)
+}
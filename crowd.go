@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/faiface/pixel"
+)
+
+const (
+	// surroundSlots is how many enemies can stand around the player at once, evenly spaced on a ring
+	surroundSlots = 8
+
+	// surroundRadius is how far, in pixels, the slots are from the player. it's inside the range of a scythe, so that
+	// enemies in their slots can attack.
+	surroundRadius = 160
+
+	// surroundRange is how close an enemy has to be to the player to be given a slot
+	surroundRange = 800
+
+	// separationRadius is how close, in pixels, enemies get before they push away from each other
+	separationRadius = 170
+
+	// separationWeight and alignmentWeight are how much separation and alignment count compared to heading for the
+	// enemy's goal
+	separationWeight = 1.5
+	alignmentWeight  = 0.4
+)
+
+// assignSlots gives the enemies closest to the target a slot around it each, so they fan out around the player
+// instead of all heading for the same point. enemies without a slot head for the target.
+func (c *enemiesCollection) assignSlots(target pixel.Vec) {
+	var candidates []*enemy
+
+	for _, e := range c.enemies {
+		e.slot = -1
+
+		if e.rect.Center().Sub(target).Len() < surroundRange {
+			candidates = append(candidates, e)
+		}
+	}
+
+	// the closest enemies pick first, so they don't get sent round to the far side of the player
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].rect.Center().Sub(target).Len() < candidates[j].rect.Center().Sub(target).Len()
+	})
+
+	var taken [surroundSlots]bool
+
+	for _, e := range candidates {
+		angle := e.rect.Center().Sub(target).Angle()
+		best, bestDist := -1, math.Inf(1)
+
+		for slot := range taken {
+			pos := slotPosition(target, slot)
+
+			// slots inside walls can't be reached
+			if taken[slot] || (c.flow != nil && !c.flow.grid.free(c.flow.grid.cellAt(pos))) {
+				continue
+			}
+
+			if d := angleDistance(angle, slotAngle(slot)); d < bestDist {
+				best, bestDist = slot, d
+			}
+		}
+
+		if best == -1 {
+			break
+		}
+
+		taken[best] = true
+		e.slot = best
+		e.slotPos = slotPosition(target, best)
+	}
+}
+
+func slotAngle(slot int) float64 {
+	return float64(slot) * 2 * math.Pi / surroundSlots
+}
+
+func slotPosition(target pixel.Vec, slot int) pixel.Vec {
+	return target.Add(pixel.V(surroundRadius, 0).Rotated(slotAngle(slot)))
+}
+
+// angleDistance is the smallest angle between two angles.
+func angleDistance(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 2*math.Pi)
+
+	if d > math.Pi {
+		d = 2*math.Pi - d
+	}
+
+	return d
+}
+
+// separation points away from the enemies crowding e, more strongly the closer they are.
+func (c *enemiesCollection) separation(e *enemy) pixel.Vec {
+	var push pixel.Vec
+
+	for i, other := range c.enemies {
+		if other == e || other.ded {
+			continue
+		}
+
+		away := e.rect.Center().Sub(other.rect.Center())
+		d := away.Len()
+
+		if d >= separationRadius {
+			continue
+		}
+
+		// enemies on top of each other split up in a direction which depends on which one they are
+		if d == 0 {
+			away = pixel.V(1, 0).Rotated(float64(i))
+		}
+
+		push = push.Add(away.Unit().Scaled(1 - d/separationRadius))
+	}
+
+	return push
+}
+
+// alignment is the average heading of the enemies near e which are following the flow field with it, so groups going
+// round an obstacle move together.
+func (c *enemiesCollection) alignment(e *enemy) pixel.Vec {
+	var heading pixel.Vec
+
+	n := 0
+
+	for _, other := range c.enemies {
+		if other == e || other.ded || !other.following || other.vel == pixel.ZV {
+			continue
+		}
+
+		if e.rect.Center().Sub(other.rect.Center()).Len() >= separationRadius*2 {
+			continue
+		}
+
+		heading = heading.Add(other.vel.Unit())
+		n++
+	}
+
+	if n == 0 {
+		return pixel.ZV
+	}
+
+	return heading.Scaled(1 / float64(n))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/faiface/pixel"
+)
+
+const (
+	// baseDifficulty is the difficulty at the start, which is the health of an enemy with a healthScale of 1
+	baseDifficulty = 100
+
+	// difficultyRampTime is how long, in seconds, it takes for the difficulty to double while the player is doing
+	// about as well as expected
+	difficultyRampTime = 180
+
+	// generatedWaveBreak is how many bars the director waits between the waves it makes up
+	generatedWaveBreak = 2
+)
+
+// director runs the waves of enemies. it spawns the level's waves in order, then makes up its own, which get bigger
+// and tougher the longer the player survives and the better they're doing. enemies are only ever spawned at the start
+// of a bar of the music.
+type director struct {
+	waves []levelWave
+	zones []pixel.Rect
+
+	// wave is the number of waves which have been started
+	wave int
+
+	// queue is the enemies left to spawn in the current wave, perBar at a time in waveZones
+	queue     []*archetype
+	perBar    int
+	waveZones []pixel.Rect
+
+	// breakBars is how many bars are left before the next wave starts, once the current one is cleared
+	breakBars int
+	lastBar   int
+
+	elapsed float64
+
+	// performance is how well the player is doing, around 1. it scales the difficulty.
+	performance float64
+}
+
+func newDirector(waves []levelWave, zones []pixel.Rect) *director {
+	return &director{
+		waves:       waves,
+		zones:       zones,
+		breakBars:   1,
+		lastBar:     -1,
+		performance: 1,
+	}
+}
+
+// difficulty is the health enemies spawn with, scaled by their archetype, and decides which archetypes can spawn.
+func (d *director) difficulty() float64 {
+	return baseDifficulty * (1 + d.elapsed/difficultyRampTime) * d.performance
+}
+
+// update advances the director. on the first step of each bar, it spawns the next enemies of the current wave, or
+// counts down the break before the next one. health is the fraction of the player's health they have left.
+func (d *director) update(dt float64, bar int, health float64, enemies *enemiesCollection) {
+	d.elapsed += dt
+
+	if bar == d.lastBar {
+		return
+	}
+
+	d.lastBar = bar
+	d.updatePerformance(health)
+
+	// the street is safe while the player is inside
+	if !spawnEnemies || !characterIsOutside {
+		return
+	}
+
+	switch {
+	case len(d.queue) > 0:
+		for i := 0; i < d.perBar && len(d.queue) > 0 && len(enemies.enemies) < maxNumberOfEnemies; i++ {
+			// the zones are full, so try again next bar
+			if !enemies.spawn(d.queue[0], d.waveZones) {
+				break
+			}
+
+			d.queue = d.queue[1:]
+		}
+	case len(enemies.enemies) > 0:
+		// wait for the wave to be cleared
+	case d.breakBars > 0:
+		d.breakBars--
+	default:
+		d.startWave()
+	}
+}
+
+// updatePerformance moves the performance towards how well the player is doing, from their health and multiplier.
+func (d *director) updatePerformance(health float64) {
+	target := 0.5 + 0.5*health + 0.1*float64(playerScore.multiplier-1)
+	target = math.Max(0.5, math.Min(1.5, target))
+
+	d.performance += (target - d.performance) / 4
+}
+
+// startWave queues up the enemies of the next wave, in a random order.
+func (d *director) startWave() {
+	var wave levelWave
+
+	if d.wave < len(d.waves) {
+		wave = d.waves[d.wave]
+	} else {
+		wave = d.generateWave()
+	}
+
+	d.wave++
+
+	var names []string
+
+	for name := range wave.Enemies {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	d.queue = nil
+
+	for _, name := range names {
+		kind := archetypes[name]
+
+		// the archetype may have been removed by a reload
+		if kind == nil {
+			continue
+		}
+
+		for i := 0; i < wave.Enemies[name]; i++ {
+			d.queue = append(d.queue, kind)
+		}
+	}
+
+	rand.Shuffle(len(d.queue), func(i, j int) {
+		d.queue[i], d.queue[j] = d.queue[j], d.queue[i]
+	})
+
+	d.perBar = wave.PerBar
+
+	if d.perBar == 0 {
+		d.perBar = 1
+	}
+
+	d.waveZones = d.zones
+
+	if len(wave.Zones) > 0 {
+		d.waveZones = nil
+
+		for _, i := range wave.Zones {
+			d.waveZones = append(d.waveZones, d.zones[i])
+		}
+	}
+
+	d.breakBars = wave.Break
+}
+
+// generateWave makes up a wave once the level's waves have run out, with more enemies the later it is.
+func (d *director) generateWave() levelWave {
+	n := d.wave - len(d.waves)
+	scale := (1 + d.elapsed/difficultyRampTime) * d.performance
+	count := int(math.Round(float64(6+2*n) * scale))
+
+	wave := levelWave{
+		Enemies: make(map[string]int),
+		PerBar:  1 + count/6,
+		Break:   generatedWaveBreak,
+	}
+
+	for i := 0; i < count; i++ {
+		if kind := pickArchetype(d.difficulty()); kind != nil {
+			wave.Enemies[kind.name]++
+		}
+	}
+
+	return wave
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+	"golang.org/x/image/colornames"
+	"golang.org/x/image/font/basicfont"
+)
+
+const (
+	// editorHandleSize is how close (in world pixels) the mouse needs to be to grab a corner or light
+	editorHandleSize = 8
+
+	editorPanSpeed = 600
+
+	editorMinGrid = 1
+	editorMaxGrid = 160
+)
+
+type editorDrag int
+
+const (
+	dragNone editorDrag = iota
+	dragWall
+	dragCorner
+	dragLight
+)
+
+// editor edits the walls and lights of the level being played. lights are updated as they're edited, and walls
+// are put into the world when leaving the editor, which restarts the level.
+//
+//	left drag:    create a wall, or move a wall, wall corner or light
+//	right click:  delete a wall or light
+//	l:            add a light
+//	tab:          change the layer new walls are added to
+//	g, [ and ]:   toggle snapping to the grid, and change its size
+//	arrows:       move the camera
+//	ctrl+s:       save the level
+type editor struct {
+	world *world
+	level *level
+
+	// layer is the layer new walls are added to
+	layer int
+
+	grid float64
+	snap bool
+
+	drag editorDrag
+
+	// dragLayer, dragWall and dragLight are what's being dragged
+	dragLayer, dragWall, dragLight int
+
+	// anchor is the corner of the wall which doesn't move while dragging a corner, and grab is where the wall
+	// was grabbed from while moving it
+	anchor, grab pixel.Vec
+
+	mouse   pixel.Vec
+	message string
+
+	imd   *imdraw.IMDraw
+	atlas *text.Atlas
+}
+
+func (e *editor) init(w *world) {
+	e.world = w
+	e.level = w.level
+	e.grid = 10
+	e.snap = true
+
+	e.imd = imdraw.New(nil)
+	e.atlas = text.NewAtlas(
+		basicfont.Face7x13,
+		text.ASCII,
+	)
+}
+
+func (e *editor) snapped(v pixel.Vec) pixel.Vec {
+	if !e.snap {
+		return v
+	}
+
+	return pixel.V(math.Round(v.X/e.grid)*e.grid, math.Round(v.Y/e.grid)*e.grid)
+}
+
+// savePath is where the level is saved to on disk. levels imported from Tiled are saved next to their map.
+func (e *editor) savePath() string {
+	return diskPath(strings.TrimSuffix(levelPath, path.Ext(levelPath)) + ".json")
+}
+
+func (e *editor) update(dt float64, canvas *pixelgl.Canvas) {
+	e.mouse = screenToWorld(canvas, win.MousePosition())
+
+	var pan pixel.Vec
+
+	if win.Pressed(pixelgl.KeyLeft) {
+		pan.X--
+	}
+
+	if win.Pressed(pixelgl.KeyRight) {
+		pan.X++
+	}
+
+	if win.Pressed(pixelgl.KeyDown) {
+		pan.Y--
+	}
+
+	if win.Pressed(pixelgl.KeyUp) {
+		pan.Y++
+	}
+
+	camPos = camPos.Add(pan.Scaled(editorPanSpeed * dt))
+
+	if win.JustPressed(pixelgl.KeyTab) && len(e.level.Layers) > 0 {
+		e.layer = (e.layer + 1) % len(e.level.Layers)
+	}
+
+	if win.JustPressed(pixelgl.KeyG) {
+		e.snap = !e.snap
+	}
+
+	if win.JustPressed(pixelgl.KeyLeftBracket) {
+		e.grid = math.Max(e.grid/2, editorMinGrid)
+	}
+
+	if win.JustPressed(pixelgl.KeyRightBracket) {
+		e.grid = math.Min(e.grid*2, editorMaxGrid)
+	}
+
+	if win.JustPressed(pixelgl.KeyS) && (win.Pressed(pixelgl.KeyLeftControl) || win.Pressed(pixelgl.KeyRightControl)) {
+		if err := e.level.save(e.savePath()); err != nil {
+			e.message = err.Error()
+		} else {
+			e.message = "saved to " + e.savePath()
+		}
+	}
+
+	if win.JustPressed(pixelgl.KeyL) {
+		e.level.Lights = append(e.level.Lights, levelLight{
+			Color:  "#e8eb23",
+			Point:  levelVecOf(e.snapped(e.mouse)),
+			Angle:  -math.Pi / 2,
+			Radius: 400,
+			Spread: math.Pi / math.E,
+			Z:      zTop,
+		})
+
+		e.world.initLights()
+	}
+
+	if win.JustPressed(pixelgl.MouseButtonRight) {
+		e.remove()
+	}
+
+	if win.JustPressed(pixelgl.MouseButtonLeft) {
+		e.startDrag()
+	}
+
+	if e.drag != dragNone {
+		if win.Pressed(pixelgl.MouseButtonLeft) {
+			e.updateDrag()
+		} else {
+			e.endDrag()
+		}
+	}
+}
+
+// lightAt finds the light under a point, or -1 if there isn't one.
+func (e *editor) lightAt(p pixel.Vec) int {
+	for i, l := range e.level.Lights {
+		if l.Point.vec().To(p).Len() <= editorHandleSize {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// wallAt finds the topmost wall under a point, or -1, -1 if there isn't one.
+func (e *editor) wallAt(p pixel.Vec) (layer, wall int) {
+	for i := len(e.level.Layers) - 1; i >= 0; i-- {
+		walls := e.level.Layers[i].Walls
+
+		for j := len(walls) - 1; j >= 0; j-- {
+			r := walls[j].rect().Norm()
+
+			// include the corners, which stick out of the wall
+			if pixel.R(r.Min.X-editorHandleSize, r.Min.Y-editorHandleSize, r.Max.X+editorHandleSize, r.Max.Y+editorHandleSize).Contains(p) {
+				return i, j
+			}
+		}
+	}
+
+	return -1, -1
+}
+
+func (e *editor) startDrag() {
+	if i := e.lightAt(e.mouse); i >= 0 {
+		e.drag = dragLight
+		e.dragLight = i
+		return
+	}
+
+	if layer, wall := e.wallAt(e.mouse); layer >= 0 {
+		r := e.level.Layers[layer].Walls[wall].rect().Norm()
+
+		e.dragLayer, e.dragWall = layer, wall
+
+		// grab a corner if the mouse is close enough to one, otherwise move the whole wall
+		for _, corner := range []pixel.Vec{r.Min, r.Max, pixel.V(r.Min.X, r.Max.Y), pixel.V(r.Max.X, r.Min.Y)} {
+			if corner.To(e.mouse).Len() <= editorHandleSize {
+				e.drag = dragCorner
+				e.anchor = r.Min.Add(r.Max).Sub(corner)
+				return
+			}
+		}
+
+		e.drag = dragWall
+		e.grab = e.mouse.Sub(r.Min)
+		return
+	}
+
+	if len(e.level.Layers) == 0 {
+		e.message = "there are no layers to add walls to"
+		return
+	}
+
+	// create a wall by dragging out its corner
+	e.anchor = e.snapped(e.mouse)
+	e.dragLayer = e.layer
+	e.dragWall = len(e.level.Layers[e.layer].Walls)
+	e.level.Layers[e.layer].Walls = append(e.level.Layers[e.layer].Walls, levelRectOf(pixel.Rect{Min: e.anchor, Max: e.anchor}))
+	e.drag = dragCorner
+}
+
+func (e *editor) updateDrag() {
+	switch e.drag {
+	case dragLight:
+		e.level.Lights[e.dragLight].Point = levelVecOf(e.snapped(e.mouse))
+		e.world.initLights()
+	case dragWall:
+		wall := &e.level.Layers[e.dragLayer].Walls[e.dragWall]
+		r := wall.rect().Norm()
+		min := e.snapped(e.mouse.Sub(e.grab))
+
+		*wall = levelRectOf(r.Moved(min.Sub(r.Min)))
+	case dragCorner:
+		e.level.Layers[e.dragLayer].Walls[e.dragWall] = levelRectOf(pixel.Rect{Min: e.anchor, Max: e.snapped(e.mouse)})
+	}
+}
+
+func (e *editor) endDrag() {
+	if e.drag == dragCorner {
+		walls := e.level.Layers[e.dragLayer].Walls
+		r := walls[e.dragWall].rect().Norm()
+
+		// remove walls which were clicked instead of dragged out
+		if r.Area() == 0 {
+			e.level.Layers[e.dragLayer].Walls = append(walls[:e.dragWall], walls[e.dragWall+1:]...)
+		} else {
+			walls[e.dragWall] = levelRectOf(r)
+		}
+	}
+
+	e.drag = dragNone
+}
+
+func (e *editor) remove() {
+	if i := e.lightAt(e.mouse); i >= 0 {
+		e.level.Lights = append(e.level.Lights[:i], e.level.Lights[i+1:]...)
+		e.world.initLights()
+		return
+	}
+
+	if layer, wall := e.wallAt(e.mouse); layer >= 0 {
+		walls := e.level.Layers[layer].Walls
+		e.level.Layers[layer].Walls = append(walls[:wall], walls[wall+1:]...)
+	}
+}
+
+func (e *editor) draw(canvas *pixelgl.Canvas) {
+	e.imd.Clear()
+
+	view := canvas.Bounds().Moved(camPos)
+
+	// grid
+	if e.snap && e.grid >= 5 {
+		e.imd.Color = pixel.Alpha(0.08)
+
+		for x := math.Floor(view.Min.X/e.grid) * e.grid; x <= view.Max.X; x += e.grid {
+			e.imd.Push(pixel.V(x, view.Min.Y), pixel.V(x, view.Max.Y))
+			e.imd.Line(1)
+		}
+
+		for y := math.Floor(view.Min.Y/e.grid) * e.grid; y <= view.Max.Y; y += e.grid {
+			e.imd.Push(pixel.V(view.Min.X, y), pixel.V(view.Max.X, y))
+			e.imd.Line(1)
+		}
+	}
+
+	for i, layer := range e.level.Layers {
+		e.imd.Color = colornames.Lemonchiffon
+
+		if i == e.layer {
+			e.imd.Color = colornames.Orangered
+		}
+
+		for _, wall := range layer.Walls {
+			r := wall.rect().Norm()
+
+			e.imd.Push(r.Min, r.Max)
+			e.imd.Rectangle(1)
+		}
+	}
+
+	for _, l := range e.level.Lights {
+		e.imd.Color = colornames.Yellow
+		e.imd.Push(l.Point.vec())
+		e.imd.Circle(editorHandleSize, 2)
+
+		e.imd.Push(l.Point.vec(), l.Point.vec().Add(pixel.V(editorHandleSize*3, 0).Rotated(l.Angle)))
+		e.imd.Line(1)
+	}
+
+	e.imd.Draw(canvas)
+
+	tx := text.New(view.Min.Add(pixel.V(10, view.H()-20)), e.atlas)
+	tx.Color = colornames.White
+
+	layer := "none"
+
+	if len(e.level.Layers) > 0 {
+		layer = e.level.Layers[e.layer].Image
+	}
+
+	snap := "off"
+
+	if e.snap {
+		snap = fmt.Sprintf("%.0f", e.grid)
+	}
+
+	fmt.Fprintf(tx, "Editor (f3 to play)  layer: %s (tab)  grid: %s (g, [, ])  x: %.0f y: %.0f\n", layer, snap, e.mouse.X, e.mouse.Y)
+	fmt.Fprintln(tx, "drag to add or move walls, right click to delete, l to add a light, ctrl+s to save")
+	fmt.Fprintln(tx, e.message)
+
+	tx.Draw(canvas, pixel.IM)
+}
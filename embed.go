@@ -0,0 +1,100 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// embeddedFS holds every asset, so the game can be run from anywhere
+//
+//go:embed images audio levels enemies
+var embeddedFS embed.FS
+
+// gameFS is the filesystem every asset is loaded from. paths in it are slash separated, relative to the repo root,
+// e.g. images/sprites/spike.png
+var gameFS fs.FS = embeddedFS
+
+// assetDir is a directory on disk which overrides the embedded assets, if it's set
+var assetDir string
+
+// useAssetDir loads assets from dir where they exist, falling back to the embedded assets.
+func useAssetDir(dir string) {
+	assetDir = dir
+	gameFS = overlayFS{os.DirFS(dir), embeddedFS}
+}
+
+// diskPath is where an asset should be written to on disk, so that it's loaded in place of the embedded asset.
+func diskPath(name string) string {
+	if assetDir == "" {
+		return filepath.FromSlash(name)
+	}
+
+	return filepath.Join(assetDir, filepath.FromSlash(name))
+}
+
+// overlayFS opens files from the first filesystem that has them. directories list the files in every filesystem.
+type overlayFS []fs.FS
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	var firstErr error
+
+	for _, fsys := range o {
+		f, err := fsys.Open(name)
+
+		if err == nil {
+			return f, nil
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries := make(map[string]fs.DirEntry)
+	found := false
+
+	// later filesystems are overridden by earlier ones
+	for i := len(o) - 1; i >= 0; i-- {
+		dir, err := fs.ReadDir(o[i], name)
+
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		found = true
+
+		for _, entry := range dir {
+			entries[entry.Name()] = entry
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	var list []fs.DirEntry
+
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+
+	return list, nil
+}
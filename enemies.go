@@ -2,8 +2,7 @@ package main
 
 import (
 	"math"
-	"path/filepath"
-	"time"
+	"math/rand"
 
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/imdraw"
@@ -14,117 +13,160 @@ const (
 	maxNumberOfEnemies = 60
 )
 
-var (
-	attackBuildUpDuration = time.Millisecond * 400
-)
-
 type enemiesCollection struct {
-	enemies []*enemy
+	enemies  []*enemy
+	director *director
+
+	// player is who the enemies are after
+	player *character
+
+	// spawnZones are the areas enemies spawn in, avoiding obstacles
+	spawnZones []pixel.Rect
+	obstacles  []*wall
 
-	counter    float64
-	step       float64
-	difficulty float64
+	// flow is shared by every enemy to find their way around the street to the player
+	flow *flowField
 
-	img pixel.Picture
+	// bolts are fired by ranged enemies
+	bolts    []*bolt
+	boltsImd *imdraw.IMDraw
 }
 
-var enemyBox = pixel.R(-84, -74, 84, 74)
+// spawnAttempts is how many random points are tried before giving up on spawning an enemy
+const spawnAttempts = 100
 
-func (e *enemiesCollection) spawnPosition() pixel.Vec {
+// spawnPosition is a random point in one of zones where an enemy with a hitbox doesn't overlap any obstacles. ok is
+// false if no such point was found.
+func (e *enemiesCollection) spawnPosition(zones []pixel.Rect, hitbox pixel.Rect) (pos pixel.Vec, ok bool) {
+	if len(zones) == 0 {
+		return pixel.ZV, false
+	}
 
-	container := streetBoundingRect.Norm()
-	container.Min.X += 600
-	container.Min.Y += 600
-	container.Max.X -= 600
-	container.Max.Y -= 600
 tryAgain:
-	generated := randomPointInRect(container)
+	for i := 0; i < spawnAttempts; i++ {
+		container := zones[rand.Intn(len(zones))].Norm()
+		generated := randomPointInRect(container)
 
-	box := enemyBox.Moved(generated).Norm()
+		box := hitbox.Moved(generated).Norm()
 
-	for _, streetCollidable := range streetCollidables {
-		if streetCollidable.Rect().Norm().Intersect(box).Area() > 0 {
-			goto tryAgain
+		for _, obstacle := range e.obstacles {
+			if obstacle.Rect().Norm().Intersect(box).Area() > 0 {
+				continue tryAgain
+			}
 		}
+
+		return generated, true
 	}
 
-	return generated
+	return pixel.ZV, false
 }
 
-func (e *enemiesCollection) newEnemy() *enemy {
+func (e *enemiesCollection) newEnemy(kind *archetype, spawnPos pixel.Vec) *enemy {
+	health := e.director.difficulty() * kind.HealthScale
+
 	return &enemy{
-		spawnPos:  e.spawnPosition(),
-		moveSpeed: 2.2,
-		health:    e.difficulty,
-		maxHealth: e.difficulty,
+		kind:  kind,
+		crowd: e,
 
-		rect: enemyBox,
+		spawnPos:     spawnPos,
+		moveSpeed:    kind.MoveSpeed,
+		acceleration: kind.Acceleration,
+		health:       health,
+		maxHealth:    health,
 
-		color1: randomNiceColor(),
-		color2: randomNiceColor(),
+		rect: kind.Hitbox.rect(),
+		slot: -1,
 
 		imd: imdraw.New(nil),
-		img: e.img,
 	}
 }
 
 func (e *enemiesCollection) init() {
-	var err error
+	e.boltsImd = imdraw.New(nil)
 
-	e.img, err = loadPicture("images/sprites/reaper")
-	if err != nil {
-		panic(err)
+	for i := range e.enemies {
+		e.enemies[i].init()
 	}
+}
 
-	e.step = 2
-	e.difficulty = 100
+// spawn spawns an enemy somewhere in zones. it returns false if there was nowhere for it to go.
+func (e *enemiesCollection) spawn(kind *archetype, zones []pixel.Rect) bool {
+	pos, ok := e.spawnPosition(zones, kind.Hitbox.rect())
 
-	for i := range e.enemies {
-		e.enemies[i].init()
+	if !ok {
+		return false
 	}
+
+	enemy := e.newEnemy(kind, pos)
+	enemy.init()
+
+	e.enemies = append(e.enemies, enemy)
+
+	return true
 }
 
-func (e *enemiesCollection) update(dt float64, targetPos pixel.Vec) {
-	e.counter += dt
+// update moves the enemies towards targetPos, where the player is. health is the fraction of the player's health they
+// have left.
+func (e *enemiesCollection) update(dt float64, targetPos pixel.Vec, health float64) {
+	if navGridChanged {
+		e.flow = newFlowField(newNavGrid(streetBoundingRect, archetypeBounds()))
+		navGridChanged = false
+	}
+
+	e.flow.update(dt, targetPos)
+	e.assignSlots(targetPos)
 
 	for i := len(e.enemies) - 1; i >= 0; i-- {
 		// If enemy is ded remove from slice
 		if e.enemies[i].ded {
-			// For every ded enemy difficulty increases
-			e.difficulty += 20
 			e.enemies = e.enemies[:i+copy(e.enemies[i:], e.enemies[i+1:])]
 		}
 	}
 
-	// e.step seconds have passed, add a new enemy (and increase spawn rate)
-	// max enemies 50 (could be more but hey)
-	if len(e.enemies) <= maxNumberOfEnemies && spawnEnemies {
-		if e.counter > e.step && characterIsOutside {
-			enemy := e.newEnemy()
+	e.director.update(dt, playerScore.audio.clock.currentBar(), health, e)
 
-			enemy.init()
+	beat := playerScore.audio.clock.visualBeat()
 
-			e.enemies = append(e.enemies, enemy)
-
-			e.step -= 1
+	for i := range e.enemies {
+		e.enemies[i].update(dt, targetPos, beat)
+	}
 
-			if e.step <= 2 {
-				e.step = 2
-			}
+	for i := len(e.bolts) - 1; i >= 0; i-- {
+		e.bolts[i].update(dt)
 
-			e.counter = 0
+		if e.bolts[i].done {
+			e.bolts = e.bolts[:i+copy(e.bolts[i:], e.bolts[i+1:])]
 		}
 	}
+}
 
-	for i := range e.enemies {
-		e.enemies[i].update(dt, targetPos)
-	}
+func (e *enemiesCollection) fire(b *bolt) {
+	b.init()
+
+	e.bolts = append(e.bolts, b)
 }
 
 func (e *enemiesCollection) draw(t pixel.Target) {
 	for i := range e.enemies {
 		e.enemies[i].draw(t)
 	}
+
+	e.boltsImd.Clear()
+
+	for _, b := range e.bolts {
+		b.draw(e.boltsImd)
+	}
+
+	e.boltsImd.Draw(t)
+}
+
+// drawBlades shows the part of each swinging scythe which hurts, for debugging.
+func (e *enemiesCollection) drawBlades(imd *imdraw.IMDraw) {
+	for _, enemy := range e.enemies {
+		if enemy.isAttacking {
+			enemy.drawBlade(imd)
+		}
+	}
 }
 
 func (e *enemiesCollection) destroy() {
@@ -135,13 +177,22 @@ func (e *enemiesCollection) destroy() {
 
 	e.enemies = make([]*enemy, 0)
 
+	for _, b := range e.bolts {
+		b.destroy()
+	}
+
+	e.bolts = nil
 }
 
 type enemy struct {
-	vel       pixel.Vec
-	moveSpeed float64
+	kind  *archetype
+	crowd *enemiesCollection
 
-	rect              pixel.Rect
+	// vel, moveSpeed and acceleration are in pixels per second
+	vel                     pixel.Vec
+	moveSpeed, acceleration float64
+
+	rect, prevRect    pixel.Rect
 	health, maxHealth float64
 	ded               bool
 	isAttacking       bool
@@ -149,31 +200,38 @@ type enemy struct {
 	spawnPos pixel.Vec
 	target   pixel.Vec
 
-	counter float64
-	step    float64
+	// slot is the enemy's place around the player, at slotPos, or -1 if it doesn't have one
+	slot    int
+	slotPos pixel.Vec
+
+	// following is set while the enemy can't see its goal, and is following the flow field
+	following bool
 
-	color1 pixel.RGBA
-	color2 pixel.RGBA
+	// beat is the beat of the music being shown, and strikeBeat is the beat the enemy's next attack lands on
+	beat, strikeBeat float64
 
-	img    pixel.Picture
 	imd    *imdraw.IMDraw
 	sprite *pixel.Sprite
 
 	//anim
-	sheet                  pixel.Picture
-	frame                  pixel.Rect
-	anims                  map[string][]pixel.Rect
-	rate, animCounter, dir float64
-	lastBuildupFrameIndex  int
-	attackBuildUpTime      time.Time
-	attackAngle            float64
-	attackAngleModifier    float64
+	sheet               pixel.Picture
+	frame               pixel.Rect
+	animator            *animator
+	dir                 float64
+	attackAngleModifier float64
+
+	// attackAngle is how far, in radians, the scythe is through its swing, which is centred on aimAngle. swingHit is
+	// set once the swing has hurt the player.
+	attackAngle, prevAttackAngle float64
+	aimAngle                     float64
+	swingHit                     bool
 
 	scythe *pixel.Sprite
 }
 
+// Vel is the distance moved by the enemy in the last simulation step.
 func (e *enemy) Vel() pixel.Vec {
-	return e.vel
+	return e.vel.Scaled(simulationStep)
 }
 
 func (e *enemy) HandleCollision(x Collidable, collisionTime float64, normal pixel.Vec) {
@@ -184,21 +242,33 @@ func (e *enemy) HandleCollision(x Collidable, collisionTime float64, normal pixe
 		playerScore.incrementScore(collidable.damage)
 
 		if e.health <= 0 {
+			playerScore.incrementScore(e.kind.Score)
 			e.die()
 		}
-	case *wall, *character, *outsideDoor:
+	default:
 		e.stopMotionCollision(collisionTime, normal)
 	}
 }
 
+func (e *enemy) Layer() collisionLayer {
+	return layerEnemy
+}
+
+func (e *enemy) Mask() collisionLayer {
+	return layerStatic | layerTrigger | layerPlayer | layerPlayerProjectile
+}
+
 func (e *enemy) stopMotionCollision(collisionTime float64, normal pixel.Vec) {
+	// move back to where the enemy hit the surface
+	back := e.Vel().Scaled(1 - collisionTime)
+
 	if normal.Y == 0 {
-		// collision in X. move back by c.body.vel (with a negated x)
-		e.rect = e.rect.Moved(e.vel.ScaledXY(pixel.V(-1, 0)))
+		// collision in X. move back by back (with a negated x)
+		e.rect = e.rect.Moved(back.ScaledXY(pixel.V(-1, 0)))
 		e.vel = e.vel.ScaledXY(pixel.V(0, 1))
 	} else {
-		// collision in Y. move back by c.body.vel (with a negated y)
-		e.rect = e.rect.Moved(e.vel.ScaledXY(pixel.V(0, -1)))
+		// collision in Y. move back by back (with a negated y)
+		e.rect = e.rect.Moved(back.ScaledXY(pixel.V(0, -1)))
 		e.vel = e.vel.ScaledXY(pixel.V(1, 0))
 	}
 }
@@ -208,34 +278,43 @@ func (e *enemy) Rect() pixel.Rect {
 }
 
 func (e *enemy) die() {
+	if e.ded {
+		return
+	}
+
 	// @TODO play death animation?
 	e.ded = true
 
 	defer deregisterCollidable(e)
+
+	assets.release(e.kind.sheetAsset())
+
+	if weapon, ok := e.kind.weaponAsset(); ok {
+		assets.release(weapon)
+	}
 }
 
 func (e *enemy) init() {
 	defer registerCollidable(e)
 
-	e.step = 1
-	e.rate = 1.0 / 10
-
-	var err error
-
-	e.sheet, e.anims, err = loadAnimationSheet("reaper", 188, filepath.Join("images", "sprites"))
+	sheet, anims, err := assets.sheet(e.kind.sheetAsset())
 
 	if err != nil {
 		panic(err)
 	}
 
+	e.sheet = sheet
+	e.initAnimator(anims)
+	e.frame = e.animator.frame()
+
 	e.imd = imdraw.New(e.sheet)
 	e.rect = e.rect.Moved(e.spawnPos)
+	e.prevRect = e.rect
 
 	e.sprite = pixel.NewSprite(nil, pixel.Rect{})
-	e.attackAngle = -1.2
 
-	if e.scythe == nil {
-		im, err := loadPicture("images/scythe")
+	if weapon, ok := e.kind.weaponAsset(); ok && e.scythe == nil {
+		im, err := assets.picture(weapon)
 
 		if err != nil {
 			panic(err)
@@ -245,76 +324,162 @@ func (e *enemy) init() {
 	}
 }
 
-func (e *enemy) update(dt float64, targetPos pixel.Vec) {
-	e.animCounter += dt
-	e.target = targetPos
+// initAnimator sets up the enemy's attack, which is in time with the music: once it's in range it builds up for the
+// telegraph before the next beat of its rhythm, then swings its scythe or fires a bolt on that beat.
+func (e *enemy) initAnimator(anims map[string]*animation) {
+	attack := e.kind.Attack
+	rhythm := attack.Rhythm
 
-	e.counter += dt
+	e.animator = newAnimator(anims)
 
-	if e.rect.Center().X < targetPos.X {
-		e.vel.X += dt
-		if e.vel.X >= e.moveSpeed {
-			e.vel.X = e.moveSpeed
+	norm := e.animator.addState("Norm", "Norm")
+	norm.enter = e.clearAttackingState
+	norm.update = func(dt float64) {
+		if e.strikeBeat <= e.beat || e.rhythmLost() {
+			e.strikeBeat = rhythm.nextStrike(e.beat + rhythm.Telegraph)
 		}
 	}
 
-	if e.rect.Center().X > targetPos.X {
-		e.vel.X -= dt
-		if e.vel.X <= -e.moveSpeed {
-			e.vel.X = -e.moveSpeed
+	building := e.animator.addState("AttackBuild", "AttackBuild")
+	attacking := e.animator.addState("Attack", "Attack")
+
+	switch attack.Kind {
+	case attackScythe:
+		building.update = func(dt float64) {
+			e.aim()
+		}
+		attacking.enter = func() {
+			e.isAttacking = true
+			e.swingHit = false
 		}
+		attacking.update = e.swing
+	case attackBolt:
+		attacking.enter = e.fireBolt
 	}
 
-	if e.rect.Center().Y < targetPos.Y {
-		e.vel.Y += dt
-		if e.vel.Y >= e.moveSpeed {
-			e.vel.Y = e.moveSpeed
-		}
+	e.animator.addTransition("", "Norm", func() bool { return e.distanceToTarget() >= attack.Range || e.rhythmLost() })
+	e.animator.addTransition("Norm", "AttackBuild", func() bool {
+		return e.distanceToTarget() < attack.Range && e.strikeBeat > e.beat && e.beat >= e.strikeBeat-rhythm.Telegraph
+	})
+	e.animator.addTransition("AttackBuild", "Attack", func() bool { return e.beat >= e.strikeBeat })
+
+	switch attack.Kind {
+	case attackScythe:
+		e.animator.addTransition("Attack", "Norm", func() bool { return e.attackAngle >= scytheSwingArc })
+	case attackBolt:
+		e.animator.addTransition("Attack", "Norm", e.animator.played)
+	}
+
+	e.animator.enter("Norm")
+}
+
+// fireBolt fires a bolt at the target.
+func (e *enemy) fireBolt() {
+	e.crowd.fire(&bolt{
+		pos:    e.rect.Center(),
+		vel:    e.target.Sub(e.rect.Center()).Unit().Scaled(e.kind.Attack.Speed),
+		damage: e.kind.Attack.Damage,
+		color:  e.kind.boltColor,
+	})
+}
+
+// rhythmLost reports whether the next strike is further away than the rhythm allows, which happens when the track
+// changes and the beat starts again.
+func (e *enemy) rhythmLost() bool {
+	rhythm := e.kind.Attack.Rhythm
+
+	return e.strikeBeat-e.beat > rhythm.Telegraph+rhythm.interval()
+}
+
+func (e *enemy) distanceToTarget() float64 {
+	return e.rect.Center().Sub(e.target).Len()
+}
+
+// reloadAssets swaps in the enemy's sheet and scythe, after they've been reloaded.
+func (e *enemy) reloadAssets() {
+	if s, ok := assets.current(e.kind.sheetAsset()).(*animationSheet); ok {
+		e.sheet = s.sheet
+		e.animator.setAnimations(s.anims)
+		e.imd = imdraw.New(e.sheet)
 	}
 
-	if e.rect.Center().Y > targetPos.Y {
-		e.vel.Y -= dt
-		if e.vel.Y <= -e.moveSpeed {
-			e.vel.Y = -e.moveSpeed
+	if weapon, ok := e.kind.weaponAsset(); ok {
+		if im, ok := assets.current(weapon).(pixel.Picture); ok {
+			e.scythe = pixel.NewSprite(im, im.Bounds())
 		}
 	}
+}
 
-	e.rect = e.rect.Moved(e.vel)
+// seek is the direction the enemy wants to go in, scaled down as it arrives. it goes straight for its slot, or the
+// target if it doesn't have one, when nothing's in the way, and otherwise follows the flow field around whatever is.
+func (e *enemy) seek(flow *flowField) pixel.Vec {
+	center := e.rect.Center()
 
-	distanceToTarget := e.rect.Center().Sub(e.target).Len()
+	goal := e.target
+	if e.slot >= 0 {
+		goal = e.slotPos
+	}
 
-	if distanceToTarget < 200 {
-		if e.lastBuildupFrameIndex != len(e.anims["AttackBuild"])-1 {
-			// we're not at the end of the attack build up. load the next attack build up frame
-			e.lastBuildupFrameIndex = int(math.Floor(e.counter/e.rate)) % len(e.anims["AttackBuild"])
-			e.frame = e.anims["AttackBuild"][e.lastBuildupFrameIndex]
+	e.following = false
 
-			e.attackBuildUpTime = time.Now()
-		} else {
-			if time.Now().Sub(e.attackBuildUpTime) > attackBuildUpDuration {
-				// we reached the end of the build up
-				e.frame = e.anims["Attack"][int(math.Floor(e.counter/e.rate))%len(e.anims["Attack"])]
-				e.isAttacking = true
+	if flow != nil && !flow.grid.lineOfSight(center, goal) {
+		if dir := flow.direction(center); dir != pixel.ZV {
+			e.following = true
 
-				if e.attackAngle > -4.5 {
-					e.attackAngle -= 0.1
-				} else {
-					e.clearAttackingState()
-				}
-			}
+			return dir
 		}
-	} else {
-		e.clearAttackingState()
 	}
+
+	toGoal := goal.Sub(center)
+
+	// slow down when arriving, no faster than the enemy can stop in, so it stops in its slot
+	arrival := math.Sqrt(2*e.acceleration*toGoal.Len()) / e.moveSpeed
+
+	return toGoal.Unit().Scaled(math.Min(1, arrival))
+}
+
+// steer is the velocity the enemy wants to move at, heading for its goal while keeping away from the rest of the
+// crowd.
+func (e *enemy) steer() pixel.Vec {
+	crowd := e.crowd
+
+	desired := e.seek(crowd.flow).
+		Add(crowd.separation(e).Scaled(separationWeight))
+
+	if e.following {
+		desired = desired.Add(crowd.alignment(e).Scaled(alignmentWeight))
+	}
+
+	if desired.Len() > 1 {
+		desired = desired.Unit()
+	}
+
+	return desired.Scaled(e.moveSpeed)
+}
+
+func (e *enemy) update(dt float64, targetPos pixel.Vec, beat float64) {
+	e.prevRect = e.rect
+	e.target = targetPos
+	e.beat = beat
+
+	// accelerate towards the desired velocity
+	change := e.steer().Sub(e.vel)
+
+	if max := e.acceleration * dt; change.Len() > max {
+		change = change.Unit().Scaled(max)
+	}
+
+	e.vel = e.vel.Add(change)
+
+	e.rect = e.rect.Moved(e.vel.Scaled(dt))
+
+	e.animator.update(dt)
+	e.frame = e.animator.frame()
 }
 
 func (e *enemy) clearAttackingState() {
-	e.attackAngle = 0
+	e.attackAngle, e.prevAttackAngle = 0, 0
 	e.isAttacking = false
-	e.lastBuildupFrameIndex = 0
-	// @TODO attacking animation
-	i := int(math.Floor(e.counter / e.rate / 2))
-	e.frame = e.anims["Norm"][i%len(e.anims["Norm"])]
 }
 
 func (e *enemy) draw(t pixel.Target) {
@@ -322,19 +487,31 @@ func (e *enemy) draw(t pixel.Target) {
 
 	e.sprite.Set(e.sheet, e.frame)
 
-	m := pixel.IM.Moved(e.rect.Center())
+	center := lerpRect(e.prevRect, e.rect, frameAlpha).Center()
+
+	m := pixel.IM.Scaled(pixel.ZV, e.kind.Scale).Moved(center)
 
 	if e.vel.X > 0 {
-		m = m.ScaledXY(e.rect.Center(), pixel.V(-1, 1))
+		m = m.ScaledXY(center, pixel.V(-1, 1))
 	}
 
-	e.sprite.DrawColorMask(t, m, pixel.RGB(h, h, h))
+	tint := pixel.ToRGBA(e.kind.tint)
+	mask := pixel.RGB(tint.R*h, tint.G*h, tint.B*h)
+
+	e.sprite.DrawColorMask(t, m, mask)
+
+	// the scythe is raised while building up to a swing
+	if e.kind.Attack.Kind == attackScythe && (e.isAttacking || e.animator.state() == "AttackBuild") {
+		e.drawScythe(t, center, mask)
+	}
 }
 
-type outsideDoor struct{}
+type outsideDoor struct {
+	rect pixel.Rect
+}
 
 func (o *outsideDoor) Rect() pixel.Rect {
-	return pixel.R(710, -540, 910, -550)
+	return o.rect
 }
 
 func (o *outsideDoor) Vel() pixel.Vec {
@@ -345,6 +522,15 @@ func (o *outsideDoor) HandleCollision(obj Collidable, collisionTime float64, nor
 
 }
 
+// Layer is a trigger, as the door only stops enemies and lasers.
+func (o *outsideDoor) Layer() collisionLayer {
+	return layerTrigger
+}
+
+func (o *outsideDoor) Mask() collisionLayer {
+	return 0
+}
+
 func (o *outsideDoor) init() {
 	registerCollidable(o)
 }
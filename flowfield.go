@@ -0,0 +1,192 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+	"golang.org/x/image/colornames"
+)
+
+const (
+	// flowFieldInterval is how often, in seconds, the flow field starts being rebuilt towards the target
+	flowFieldInterval = 0.25
+
+	// flowFieldBudget is how many cells are settled each step while the flow field is being rebuilt
+	flowFieldBudget = 2000
+)
+
+// flowField points every cell of a navigation grid along the shortest path to a target, so any number of enemies can
+// find their way by looking up the cell they're in. it's rebuilt a few times a second, spread over several steps, and
+// the last finished field is used in the meantime.
+type flowField struct {
+	grid *navGrid
+
+	target pixel.Vec
+	cost   []float64
+	dirs   []pixel.Vec
+
+	// age is how long it's been since the last rebuild started
+	age float64
+
+	// building is set while a rebuild is in progress, towards buildTarget
+	building    bool
+	buildTarget pixel.Vec
+	buildCost   []float64
+	open        navQueue
+}
+
+func newFlowField(grid *navGrid) *flowField {
+	return &flowField{
+		grid: grid,
+		dirs: make([]pixel.Vec, len(grid.blocked)),
+	}
+}
+
+func (f *flowField) update(dt float64, target pixel.Vec) {
+	f.age += dt
+
+	if !f.building && (f.cost == nil || f.age >= flowFieldInterval) {
+		f.start(target)
+	}
+
+	if f.building {
+		budget := flowFieldBudget
+
+		// there's no finished field to fall back on, so build all of it now
+		if f.cost == nil {
+			budget = len(f.grid.blocked)
+		}
+
+		f.expand(budget)
+	}
+}
+
+// start begins rebuilding the field towards a target.
+func (f *flowField) start(target pixel.Vec) {
+	f.age = 0
+	f.building = true
+	f.buildTarget = target
+
+	if f.buildCost == nil {
+		f.buildCost = make([]float64, len(f.grid.blocked))
+	}
+
+	for i := range f.buildCost {
+		f.buildCost[i] = math.Inf(1)
+	}
+
+	goal := f.grid.cellAt(target)
+	f.buildCost[f.grid.index(goal)] = 0
+	f.open = append(f.open[:0], navNode{cell: goal})
+}
+
+// expand settles up to n cells of the field being built, outwards from the target, finishing it if there are none
+// left.
+func (f *flowField) expand(n int) {
+	for ; n > 0 && f.open.Len() > 0; n-- {
+		node := heap.Pop(&f.open).(navNode)
+		i := f.grid.index(node.cell)
+
+		// the cell was reached more cheaply since this was queued
+		if node.priority > f.buildCost[i] {
+			continue
+		}
+
+		for _, step := range navNeighbours {
+			if !f.grid.canStep(node.cell, step.dx, step.dy) {
+				continue
+			}
+
+			next := navCell{node.cell.x + step.dx, node.cell.y + step.dy}
+			j := f.grid.index(next)
+			cost := f.buildCost[i] + step.cost
+
+			if cost < f.buildCost[j] {
+				f.buildCost[j] = cost
+				heap.Push(&f.open, navNode{cell: next, priority: cost})
+			}
+		}
+	}
+
+	if f.open.Len() == 0 {
+		f.finish()
+	}
+}
+
+// finish swaps in the field which has been built, pointing each cell at its cheapest neighbour.
+func (f *flowField) finish() {
+	f.building = false
+	f.target = f.buildTarget
+	f.cost, f.buildCost = f.buildCost, f.cost
+
+	for y := 0; y < f.grid.rows; y++ {
+		for x := 0; x < f.grid.cols; x++ {
+			cell := navCell{x, y}
+			i := f.grid.index(cell)
+
+			f.dirs[i] = pixel.ZV
+
+			if !f.grid.free(cell) {
+				continue
+			}
+
+			best := f.cost[i]
+
+			for _, step := range navNeighbours {
+				if !f.grid.canStep(cell, step.dx, step.dy) {
+					continue
+				}
+
+				j := f.grid.index(navCell{x + step.dx, y + step.dy})
+
+				if f.cost[j] < best {
+					best = f.cost[j]
+					f.dirs[i] = pixel.V(float64(step.dx), float64(step.dy)).Unit()
+				}
+			}
+		}
+	}
+}
+
+// direction is the way to go from pos towards the target, or the zero vector if there's no way there from pos.
+func (f *flowField) direction(pos pixel.Vec) pixel.Vec {
+	if f.cost == nil {
+		return pixel.ZV
+	}
+
+	return f.dirs[f.grid.index(f.grid.cellAt(pos))]
+}
+
+// draw shows which way each cell points, and which cells are blocked.
+func (f *flowField) draw(imd *imdraw.IMDraw) {
+	half := pixel.V(navCellSize/2-1, navCellSize/2-1)
+
+	for y := 0; y < f.grid.rows; y++ {
+		for x := 0; x < f.grid.cols; x++ {
+			cell := navCell{x, y}
+			center := f.grid.center(cell)
+
+			if !f.grid.free(cell) {
+				imd.Color = colornames.Darkred
+				imd.Push(center.Sub(half), center.Add(half))
+				imd.Rectangle(1)
+
+				continue
+			}
+
+			dir := f.dirs[f.grid.index(cell)]
+
+			if dir == pixel.ZV {
+				continue
+			}
+
+			imd.Color = colornames.Lightseagreen
+			imd.Push(center)
+			imd.Circle(2, 0)
+			imd.Push(center, center.Add(dir.Scaled(navCellSize*0.4)))
+			imd.Line(1)
+		}
+	}
+}
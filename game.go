@@ -4,34 +4,67 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"path"
 	"time"
 
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/imdraw"
 	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
 	"golang.org/x/image/colornames"
+	"golang.org/x/image/font/basicfont"
 )
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+const (
+	// simulationStep is the fixed length of time, in seconds, that the game is updated by
+	simulationStep = 1.0 / 120
+
+	// maxFrameTime is the most time a single frame can advance the simulation by
+	maxFrameTime = 0.25
+)
+
 var (
 	win    *pixelgl.Window
 	camPos pixel.Vec
 
+	// frameAlpha is how far between the previous and current simulation step the frame being drawn is
+	frameAlpha float64
+
 	playerScore    *score
-	playerSpawnPos = pixel.V(-625, -50)
+	playerSpawnPos pixel.Vec
 )
 
 type game struct {
-	world *world
+	level       *level
+	preload     *assetPreload
+	world       *world
+	calibration *calibration
+	editor      *editor
+
+	// watcher and reloadErrors are used to reload assets in dev mode
+	watcher      *watcher
+	reloadErrors []error
+	atlas        *text.Atlas
 
 	collisionBoxes *imdraw.IMDraw
 }
 
 func (g *game) init() error {
-	g.world = &world{}
+	if g.level == nil {
+		var err error
+
+		g.level, err = loadLevel(gameFS, levelPath)
+
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	g.world = &world{level: g.level}
 	g.world.init()
 	ded = false
 
@@ -66,6 +99,14 @@ func (g *game) draw(canvas *pixelgl.Canvas) {
 		g.drawCollisionBoxes(canvas)
 	}
 
+	g.drawReloadErrors(canvas)
+
+	g.present(canvas)
+	playerScore.draw(win, canvas)
+}
+
+// present scales the canvas to fit the window and draws it.
+func (g *game) present(canvas *pixelgl.Canvas) {
 	win.Clear(colornames.White)
 	win.SetMatrix(pixel.IM.Scaled(pixel.ZV,
 		math.Min(
@@ -74,13 +115,10 @@ func (g *game) draw(canvas *pixelgl.Canvas) {
 		),
 	).Moved(win.Bounds().Center()))
 	canvas.Draw(win, pixel.IM.Moved(canvas.Bounds().Center()))
-	playerScore.draw(win, canvas)
 }
 
 func (g *game) collisions() {
-	for c := range collidables {
-		checkCollisions(c)
-	}
+	checkAllCollisions()
 }
 
 func (g *game) drawCollisionBoxes(t pixel.Target) {
@@ -90,6 +128,12 @@ func (g *game) drawCollisionBoxes(t pixel.Target) {
 
 	g.collisionBoxes.Clear()
 
+	if g.world.enemies.flow != nil {
+		g.world.enemies.flow.draw(g.collisionBoxes)
+	}
+
+	g.world.enemies.drawBlades(g.collisionBoxes)
+
 	for collidable := range collidables {
 		g.collisionBoxes.Color = colornames.Lemonchiffon
 		rect := collidable.Rect()
@@ -107,12 +151,86 @@ func (g *game) drawCollisionBoxes(t pixel.Target) {
 	g.collisionBoxes.Draw(t)
 }
 
+// assetRequests are the assets loaded before the game starts
+func (g *game) assetRequests() []assetRequest {
+	requests := []assetRequest{spikeSheet, armPicture, phaserSound}
+	requests = append(requests, archetypeAssets()...)
+
+	for _, layer := range g.level.Layers {
+		requests = append(requests, layerAsset(layer))
+	}
+
+	for _, name := range trackNames() {
+		if tracks[name].buffered {
+			requests = append(requests, soundAsset(tracks[name].filepath))
+		}
+	}
+
+	return requests
+}
+
+// load loads the level and preloads its assets, showing a loading screen until they're ready. if anything fails to
+// load, the error is shown until the window is closed, and load returns false.
+func (g *game) load(canvas *pixelgl.Canvas) bool {
+	var err error
+
+	g.level, err = loadLevel(gameFS, levelPath)
+
+	if err == nil {
+		g.preload = assets.preload(g.assetRequests()...)
+	}
+
+	atlas := text.NewAtlas(basicfont.Face7x13, text.ASCII)
+
+	for !win.Closed() {
+		if err == nil && g.preload.finished() {
+			err = g.preload.err()
+
+			if err == nil {
+				err = checkArchetypeSheets(archetypes)
+			}
+
+			if err == nil {
+				return true
+			}
+		}
+
+		canvas.Clear(colornames.Black)
+		canvas.SetMatrix(pixel.IM)
+
+		tx := text.New(pixel.V(-200, 0), atlas)
+		tx.Color = colornames.White
+
+		if err != nil {
+			fmt.Fprintln(tx, "Failed to load:")
+			fmt.Fprintln(tx, err)
+		} else {
+			loaded, total := g.preload.progress()
+			fmt.Fprintf(tx, "Loading... %d/%d", loaded, total)
+		}
+
+		tx.Draw(canvas, pixel.IM)
+		g.present(canvas)
+
+		win.Update()
+	}
+
+	return false
+}
+
 func (g *game) run() {
+	canvas := pixelgl.NewCanvas(pixel.R(-1920/3, -1080/3, 1920/3, 1080/3))
+
+	if !g.load(canvas) {
+		return
+	}
+
 	g.init()
 
-	second := time.Tick(time.Second)
+	g.watcher = watchAssets()
+	g.atlas = text.NewAtlas(basicfont.Face7x13, text.ASCII)
 
-	canvas := pixelgl.NewCanvas(pixel.R(-1920/3, -1080/3, 1920/3, 1080/3))
+	second := time.Tick(time.Second)
 	last := time.Now()
 	frames := 0
 
@@ -130,37 +248,102 @@ func (g *game) run() {
 
 	frameLimit := time.Tick(time.Second / 144)
 
+	// accumulator is the simulation time which has passed but not yet been stepped through
+	accumulator := 0.0
+
 	for !win.Closed() {
 		dt := time.Since(last).Seconds()
 		last = time.Now()
 
 		//iTime += float32(dt)
 
-		// lerp the camera position towards the body
-		camPos = pixel.Lerp(camPos, g.world.character.body.rect.Center(), 1-math.Pow(1.0/128, dt))
-		cam := pixel.IM.Moved(camPos.Scaled(-1))
-		canvas.SetMatrix(cam)
+		// slow motion with tab, which the editor uses to switch layers
+		if win.Pressed(pixelgl.KeyTab) && g.editor == nil {
+			dt /= 8
+		}
 
-		// Q: Why are these position modifiers different for each axis?
-		// A: I have no clue.
-		iLightPos[0] = float32(0.002 - camPos.X*0.0008)
-		iLightPos[1] = float32(-0.15 - camPos.Y*0.0014)
+		// calibrate audio and visual latency with f2
+		if win.JustPressed(pixelgl.KeyF2) && g.calibration == nil && g.editor == nil {
+			g.calibration = &calibration{}
+			g.calibration.init()
+		}
 
-		// slow motion with tab
-		if win.Pressed(pixelgl.KeyTab) {
-			dt /= 8
+		if g.watcher != nil {
+			select {
+			case changed := <-g.watcher.changes:
+				g.reload(changed)
+			default:
+			}
 		}
 
-		// restart the level on pressing enter
-		if win.JustPressed(pixelgl.KeyEnter) {
-			g.destroy()
-			g.init()
+		// edit the level with f3, restarting it with the changes when leaving the editor
+		if win.JustPressed(pixelgl.KeyF3) && g.calibration == nil {
+			if g.editor == nil {
+				g.editor = &editor{}
+				g.editor.init(g.world)
+			} else {
+				g.editor = nil
+				g.destroy()
+				g.init()
+			}
 		}
 
-		g.collisions()
-		g.update(dt)
+		if g.calibration != nil {
+			g.calibration.update()
+			g.calibration.draw(canvas)
+			g.present(canvas)
+
+			if g.calibration.done {
+				// restart the level, which restarts the music
+				g.calibration = nil
+				g.destroy()
+				g.init()
+			}
+		} else if g.editor != nil {
+			g.editor.update(dt, canvas)
+			canvas.SetMatrix(pixel.IM.Moved(camPos.Scaled(-1)))
+
+			canvas.Clear(colornames.Black)
+			g.world.draw(canvas)
+			g.editor.draw(canvas)
+			g.drawReloadErrors(canvas)
+			g.present(canvas)
+		} else {
+			// restart the level on pressing enter
+			if win.JustPressed(pixelgl.KeyEnter) {
+				g.destroy()
+				g.init()
+			}
+
+			latchInput()
+
+			// step the simulation at a fixed rate, dropping time if we've fallen too far behind to catch up
+			accumulator += math.Min(dt, maxFrameTime)
+
+			for accumulator >= simulationStep {
+				g.collisions()
+				g.update(simulationStep)
+				clearInput()
+
+				accumulator -= simulationStep
+			}
+
+			// draw between the last two simulation steps
+			frameAlpha = accumulator / simulationStep
+
+			// lerp the camera position towards the body
+			camPos = pixel.Lerp(camPos, g.world.character.body.interpolatedRect().Center(), 1-math.Pow(1.0/128, dt))
+			cam := pixel.IM.Moved(camPos.Scaled(-1))
+			canvas.SetMatrix(cam)
+
+			// Q: Why are these position modifiers different for each axis?
+			// A: I have no clue.
+			iLightPos[0] = float32(0.002 - camPos.X*0.0008)
+			iLightPos[1] = float32(-0.15 - camPos.Y*0.0014)
+
+			g.draw(canvas)
+		}
 
-		g.draw(canvas)
 		win.Update()
 
 		frames++
@@ -176,5 +359,91 @@ func (g *game) run() {
 }
 
 func (g *game) destroy() {
-	g.world.destroy()
+	g.world.unload()
+}
+
+// reload reloads the assets and level loaded from changed files, keeping the old ones if they fail to load.
+func (g *game) reload(changed []string) {
+	g.reloadErrors = assets.reload(changed)
+	g.world.reloadAssets()
+
+	// enemies which have already spawned keep their old archetype
+	for _, file := range changed {
+		if path.Dir(file) != archetypeDir {
+			continue
+		}
+
+		a, err := loadArchetypes(gameFS, archetypeDir)
+
+		if err == nil {
+			err = checkArchetypeSheets(a)
+		}
+
+		if err != nil {
+			g.reloadErrors = append(g.reloadErrors, err)
+			break
+		}
+
+		archetypes = a
+
+		break
+	}
+
+	for _, file := range changed {
+		if path.Dir(file) != path.Dir(levelPath) {
+			continue
+		}
+
+		if g.editor != nil {
+			g.reloadErrors = append(g.reloadErrors, fmt.Errorf("%s changed while editing, leave the editor to reload it", file))
+			break
+		}
+
+		l, err := loadLevel(gameFS, levelPath)
+
+		if err != nil {
+			g.reloadErrors = append(g.reloadErrors, err)
+			break
+		}
+
+		g.world.unload()
+		g.level = l
+		g.world = &world{level: l}
+		g.world.init()
+
+		break
+	}
+}
+
+// drawReloadErrors shows why the last reload failed, at the top left of the screen.
+func (g *game) drawReloadErrors(canvas *pixelgl.Canvas) {
+	if len(g.reloadErrors) == 0 {
+		return
+	}
+
+	view := canvas.Bounds().Moved(camPos)
+
+	tx := text.New(view.Min.Add(pixel.V(10, view.H()-60)), g.atlas)
+	tx.Color = colornames.Red
+
+	for _, err := range g.reloadErrors {
+		fmt.Fprintln(tx, err)
+	}
+
+	tx.Draw(canvas, pixel.IM)
+}
+
+// screenToWorld converts a point in the window to a point in the world, for a canvas drawn with present.
+func screenToWorld(canvas *pixelgl.Canvas, p pixel.Vec) pixel.Vec {
+	scale := math.Min(
+		win.Bounds().W()/canvas.Bounds().W(),
+		win.Bounds().H()/canvas.Bounds().H(),
+	)
+
+	return p.Sub(win.Bounds().Center()).Scaled(1 / scale).Add(canvas.Bounds().Center()).Add(camPos)
+}
+
+// lerpRect interpolates between two rects, for drawing between simulation steps.
+func lerpRect(a, b pixel.Rect, t float64) pixel.Rect {
+	return pixel.Rect{Min: pixel.Lerp(a.Min, b.Min, t), Max: pixel.Lerp(a.Max, b.Max, t)}
 }
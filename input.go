@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/faiface/pixel/pixelgl"
+)
+
+// simulationButtons are the buttons read with justPressed during a simulation step. the window only knows whether
+// a button was pressed since the last frame, but a frame can run any number of simulation steps, so presses are
+// latched each frame and seen by exactly one step.
+var simulationButtons = []pixelgl.Button{
+	pixelgl.MouseButtonLeft,
+	pixelgl.MouseButtonRight,
+}
+
+var stepPresses = make(map[pixelgl.Button]bool)
+
+// latchInput records the buttons pressed this frame, keeping any which haven't been seen by a step yet.
+func latchInput() {
+	for _, button := range simulationButtons {
+		if win.JustPressed(button) {
+			stepPresses[button] = true
+		}
+	}
+}
+
+// clearInput forgets the presses seen by the last simulation step.
+func clearInput() {
+	for button := range stepPresses {
+		delete(stepPresses, button)
+	}
+}
+
+// justPressed is the simulation step equivalent of win.JustPressed.
+func justPressed(button pixelgl.Button) bool {
+	return stepPresses[button]
+}
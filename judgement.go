@@ -0,0 +1,81 @@
+package main
+
+import (
+	"time"
+)
+
+// judgement grades how close to the beat a shot was fired
+type judgement int
+
+const (
+	miss judgement = iota
+	good
+	great
+	perfect
+
+	numJudgements
+)
+
+func (j judgement) String() string {
+	switch j {
+	case perfect:
+		return "Perfect"
+	case great:
+		return "Great"
+	case good:
+		return "Good"
+	default:
+		return "Miss"
+	}
+}
+
+type judgementTier struct {
+	judgement judgement
+
+	// window is the furthest a shot can be from the beat (early or late) and still be given this judgement
+	window time.Duration
+
+	// progress is added to the multiplier progress. 8 progress increases the multiplier.
+	progress int
+
+	// damage scales the damage of the laser fired
+	damage float64
+}
+
+// judgementTiers are checked in order, so should go from the tightest window to the widest. anything outside of
+// every window is a miss.
+var judgementTiers = []judgementTier{
+	{judgement: perfect, window: time.Millisecond * 35, progress: 2, damage: 1.5},
+	{judgement: great, window: time.Millisecond * 70, progress: 1, damage: 1},
+	{judgement: good, window: time.Millisecond * 110, progress: 1, damage: 0.75},
+}
+
+var missTier = judgementTier{judgement: miss, progress: -2, damage: 0.5}
+
+// judge finds the tier for a shot fired offset from the nearest beat.
+func judge(offset time.Duration) judgementTier {
+	if offset < 0 {
+		offset = -offset
+	}
+
+	for _, tier := range judgementTiers {
+		if offset <= tier.window {
+			return tier
+		}
+	}
+
+	return missTier
+}
+
+// beatWindow is the widest window which doesn't result in a miss.
+func beatWindow() time.Duration {
+	var window time.Duration
+
+	for _, tier := range judgementTiers {
+		if tier.window > window {
+			window = tier.window
+		}
+	}
+
+	return window
+}
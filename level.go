@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/faiface/pixel"
+)
+
+// levelPath is the level loaded by the world
+var levelPath = "levels/world.json"
+
+// level describes a map: the layers it is drawn with, what collides, its lights and where things spawn. points are
+// [x, y] and rects are [x1, y1, x2, y2].
+type level struct {
+	PlayerSpawn levelVec `json:"playerSpawn"`
+
+	// Outside is the street area, where it rains and enemies are spawned.
+	Outside levelRect `json:"outside"`
+
+	// Door is the door out to the street, which enemies can't pass through.
+	Door levelRect `json:"door"`
+
+	SpawnZones []levelRect `json:"spawnZones"`
+
+	// Waves are spawned in order, before the director starts making up its own.
+	Waves []levelWave `json:"waves,omitempty"`
+
+	Adverts []levelAdvert `json:"adverts"`
+	Layers  []levelLayer  `json:"layers"`
+	Lights  []levelLight  `json:"lights"`
+}
+
+type levelVec [2]float64
+
+func (v levelVec) vec() pixel.Vec {
+	return pixel.V(v[0], v[1])
+}
+
+func levelVecOf(v pixel.Vec) levelVec {
+	return levelVec{v.X, v.Y}
+}
+
+type levelRect [4]float64
+
+func (r levelRect) rect() pixel.Rect {
+	return pixel.R(r[0], r[1], r[2], r[3])
+}
+
+func levelRectOf(r pixel.Rect) levelRect {
+	return levelRect{r.Min.X, r.Min.Y, r.Max.X, r.Max.Y}
+}
+
+// layerZ is where a layer is drawn relative to the characters and enemies
+type layerZ string
+
+const (
+	zBottom layerZ = "bottom"
+	zTop    layerZ = "top"
+
+	// zAnim layers are animated, and are drawn below the characters
+	zAnim layerZ = "anim"
+)
+
+type levelLayer struct {
+	// Image is the name of the image in images/world/rooms, without the extension.
+	Image  string   `json:"image"`
+	Z      layerZ   `json:"z"`
+	Offset levelVec `json:"offset"`
+
+	// FrameWidth is used by animated layers, which play the Norm animation of their sheet. the sheet's json sets how
+	// fast it plays.
+	FrameWidth float64 `json:"frameWidth,omitempty"`
+
+	Walls []levelRect `json:"walls,omitempty"`
+}
+
+type levelLight struct {
+	// Color is a hex colour, e.g. #e8eb23
+	Color  string   `json:"color"`
+	Point  levelVec `json:"point"`
+	Angle  float64  `json:"angle"`
+	Radius float64  `json:"radius"`
+	Spread float64  `json:"spread"`
+
+	// Z is bottom to draw the light under the top layers, or top to draw it over them.
+	Z layerZ `json:"z"`
+}
+
+type levelAdvert struct {
+	Pos   levelVec `json:"pos"`
+	Width int      `json:"width"`
+}
+
+// levelWave is a wave of enemies, e.g. {"enemies": {"reaper": 6, "wraith": 2}, "perBar": 2, "break": 2}
+type levelWave struct {
+	// Enemies is how many of each archetype the wave spawns, keyed by the archetype's name.
+	Enemies map[string]int `json:"enemies"`
+
+	// Zones are the indices of the spawn zones the wave spawns in. it uses all of them if it's empty.
+	Zones []int `json:"zones,omitempty"`
+
+	// PerBar is how many enemies are spawned at the start of each bar, 1 if it isn't set.
+	PerBar int `json:"perBar,omitempty"`
+
+	// Break is how many bars to wait after the wave is cleared before the next one starts.
+	Break int `json:"break,omitempty"`
+}
+
+// loadLevel loads a level file, or imports a Tiled map if name is a .tmx file.
+func loadLevel(fsys fs.FS, name string) (*level, error) {
+	if path.Ext(name) == ".tmx" {
+		return importTMX(fsys, name)
+	}
+
+	f, err := fsys.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var l level
+
+	if err := json.NewDecoder(f).Decode(&l); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	if err := l.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return &l, nil
+}
+
+// save writes the level to a level file. Tiled maps can't be written back to, so path should be a .json file.
+func (l *level) save(path string) error {
+	f, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+
+	return enc.Encode(l)
+}
+
+func (l *level) validate() error {
+	for _, layer := range l.Layers {
+		switch layer.Z {
+		case zBottom, zTop:
+		case zAnim:
+			if layer.FrameWidth <= 0 {
+				return fmt.Errorf("layer %s: animated layers need a frameWidth", layer.Image)
+			}
+		default:
+			return fmt.Errorf("layer %s: unknown z %q", layer.Image, layer.Z)
+		}
+	}
+
+	for _, light := range l.Lights {
+		if _, err := parseHexColor(light.Color); err != nil {
+			return err
+		}
+
+		if light.Z != zBottom && light.Z != zTop {
+			return fmt.Errorf("light at %v: unknown z %q", light.Point, light.Z)
+		}
+	}
+
+	if err := l.validateSpawnZones(); err != nil {
+		return err
+	}
+
+	for i, wave := range l.Waves {
+		for name, count := range wave.Enemies {
+			// archetypes aren't loaded when importing levels
+			if archetypes != nil && archetypes[name] == nil {
+				return fmt.Errorf("wave %d: unknown enemy %q", i, name)
+			}
+
+			if count < 0 {
+				return fmt.Errorf("wave %d: negative count of %s", i, name)
+			}
+		}
+
+		for _, zone := range wave.Zones {
+			if zone < 0 || zone >= len(l.SpawnZones) {
+				return fmt.Errorf("wave %d: no spawn zone %d", i, zone)
+			}
+		}
+
+		if wave.PerBar < 0 || wave.Break < 0 {
+			return fmt.Errorf("wave %d: perBar and break can't be negative", i)
+		}
+	}
+
+	return nil
+}
+
+// validateSpawnZones checks there's at least one spawn zone big enough for every enemy.
+func (l *level) validateSpawnZones() error {
+	if len(l.SpawnZones) == 0 {
+		return fmt.Errorf("no spawn zones")
+	}
+
+	// archetypes aren't loaded when importing levels
+	if archetypes == nil {
+		return nil
+	}
+
+	hitbox := archetypeBounds()
+
+	for _, zone := range l.SpawnZones {
+		if r := zone.rect().Norm(); r.W() >= hitbox.W() && r.H() >= hitbox.H() {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no spawn zone is big enough for a %vx%v hitbox", hitbox.W(), hitbox.H())
+}
+
+// parseHexColor parses a colour in the form #rrggbb
+func parseHexColor(s string) (color.RGBA, error) {
+	c := color.RGBA{A: 255}
+
+	if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return c, fmt.Errorf("invalid colour %q", s)
+	}
+
+	return c, nil
+}
@@ -1,101 +1,125 @@
 package main
 
 import (
-	"encoding/csv"
-	"image"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	_ "image/png"
-	"io"
 	"math"
 	"math/rand"
 	"os"
-	"path/filepath"
-	"strconv"
 
+	"github.com/faiface/beep/speaker"
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
 )
 
-func loadAnimationSheet(name string, frameWidth float64, sheetBasePath string) (sheet pixel.Picture, anims map[string][]pixel.Rect, err error) {
-	// open and load the spritesheet
-	sheetFile, err := os.Open(filepath.Join(sheetBasePath, name+".png"))
-
-	if err != nil {
-		return nil, nil, err
-	}
-
-	defer sheetFile.Close()
-
-	sheetImg, _, err := image.Decode(sheetFile)
-
-	if err != nil {
-		return nil, nil, err
+func randomNiceColor() pixel.RGBA {
+	r := rand.Float64()
+	g := rand.Float64()
+	b := rand.Float64()
+	l := math.Sqrt(r*r + g*g + b*b)
+	if l == 0 {
+		return randomNiceColor()
 	}
+	return pixel.RGB(r/l, g/l, b/l)
+}
 
-	sheet = pixel.PictureDataFromImage(sheetImg)
+// errUsage is returned by a command given the wrong arguments, once it has printed its usage
+var errUsage = errors.New("usage")
 
-	// create a slice of frames inside the spritesheet
-	var frames []pixel.Rect
+// writeJSON writes v as indented json to the file at path, or to stdout if path is empty.
+func writeJSON(path string, v interface{}) (err error) {
+	w := os.Stdout
 
-	for x := 0.0; x+frameWidth <= sheet.Bounds().Max.X; x += frameWidth {
-		frames = append(frames, pixel.R(
-			x,
-			0,
-			x+frameWidth,
-			sheet.Bounds().H(),
-		))
-	}
+	if path != "" {
+		w, err = os.Create(path)
 
-	descFile, err := os.Open(filepath.Join(sheetBasePath, name+".csv"))
+		if err != nil {
+			return err
+		}
 
-	if err != nil {
-		return nil, nil, err
+		// a failed close can mean the file wasn't completely written
+		defer func() {
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+		}()
 	}
 
-	defer descFile.Close()
-
-	anims = make(map[string][]pixel.Rect)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
 
-	// load the animation information, name and interval inside the spritesheet
-	desc := csv.NewReader(descFile)
+	return enc.Encode(v)
+}
 
-	for {
-		anim, err := desc.Read()
-		if err == io.EOF {
-			break
+func main() {
+	if len(os.Args) > 1 {
+		var command func([]string) error
+
+		switch os.Args[1] {
+		case "analyze":
+			command = analyzeCommand
+		case "import":
+			command = importCommand
 		}
 
-		if err != nil {
-			return nil, nil, err
-		}
+		if command != nil {
+			err := command(os.Args[2:])
 
-		name := anim[0]
-		start, _ := strconv.Atoi(anim[1])
-		end, _ := strconv.Atoi(anim[2])
+			switch {
+			case errors.Is(err, errUsage):
+				os.Exit(2)
+			case err != nil:
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
 
-		anims[name] = frames[start : end+1]
+			return
+		}
 	}
 
-	return sheet, anims, nil
-}
+	dir := flag.String("assets", "", "load assets from this directory, where they exist, instead of the embedded assets")
+	flag.BoolVar(&devMode, "dev", false, "reload assets when they change on disk, from the -assets directory or the working directory")
+	flag.Parse()
 
-func randomNiceColor() pixel.RGBA {
-	r := rand.Float64()
-	g := rand.Float64()
-	b := rand.Float64()
-	l := math.Sqrt(r*r + g*g + b*b)
-	if l == 0 {
-		return randomNiceColor()
+	if *dir != "" {
+		useAssetDir(*dir)
+	} else if devMode {
+		useAssetDir(".")
 	}
-	return pixel.RGB(r/l, g/l, b/l)
-}
 
-func main() {
 	pixelgl.Run(run)
 }
 
 func run() {
 	var err error
 
+	tracks, err = loadTracks(gameFS, "audio/tracks")
+
+	if err != nil {
+		panic(err)
+	}
+
+	archetypes, err = loadArchetypes(gameFS, archetypeDir)
+
+	if err != nil {
+		panic(err)
+	}
+
+	userSettings, err = loadSettings()
+
+	if err != nil {
+		panic(err)
+	}
+
+	for _, name := range []string{defaultTrack, deathTrack} {
+		if tracks[name] == nil {
+			panic("missing beatmap for track: " + name)
+		}
+	}
+
 	win, err = pixelgl.NewWindow(pixelgl.WindowConfig{
 		Title:     "Beat Phaser",
 		Bounds:    pixel.R(0, 0, 1920, 1080),
@@ -108,4 +132,13 @@ func run() {
 
 	game := &game{}
 	game.run()
+
+	// stop the music before closing the files it's streamed from
+	speaker.Clear()
+
+	for _, name := range trackNames() {
+		if err := tracks[name].close(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"math"
+
+	"github.com/faiface/pixel"
+)
+
+// navCellSize is the size of the cells in the navigation grid
+const navCellSize = 32
+
+// navGridChanged is set whenever something enemies have to go around is registered or deregistered, so the
+// navigation grid is rebuilt
+var navGridChanged = true
+
+// blocksNavigation reports whether enemies have to path around a collidable. enemies stop at static collidables and
+// triggers, like the door.
+func blocksNavigation(c Collidable) bool {
+	return c.Layer()&(layerStatic|layerTrigger) != 0
+}
+
+type navCell struct {
+	x, y int
+}
+
+// navGrid divides an area into cells, marking the ones an agent can't stand in
+type navGrid struct {
+	bounds     pixel.Rect
+	cols, rows int
+	blocked    []bool
+}
+
+// newNavGrid builds a grid over bounds from the registered collidables which block navigation. they're grown by half
+// of the agent's size, so an agent whose center is in a free cell doesn't overlap any of them.
+func newNavGrid(bounds pixel.Rect, agent pixel.Rect) *navGrid {
+	bounds = bounds.Norm()
+
+	g := &navGrid{
+		bounds: bounds,
+		cols:   int(math.Ceil(bounds.W() / navCellSize)),
+		rows:   int(math.Ceil(bounds.H() / navCellSize)),
+	}
+
+	g.blocked = make([]bool, g.cols*g.rows)
+
+	half := agent.Norm().Size().Scaled(0.5)
+
+	for _, c := range orderedCollidables() {
+		if !blocksNavigation(c) {
+			continue
+		}
+
+		r := c.Rect().Norm()
+		r = pixel.R(r.Min.X-half.X, r.Min.Y-half.Y, r.Max.X+half.X, r.Max.Y+half.Y)
+
+		min, max := g.cellAt(r.Min), g.cellAt(r.Max)
+
+		for x := min.x; x <= max.x; x++ {
+			for y := min.y; y <= max.y; y++ {
+				cell := navCell{x, y}
+
+				if r.Contains(g.center(cell)) {
+					g.blocked[g.index(cell)] = true
+				}
+			}
+		}
+	}
+
+	return g
+}
+
+// cellAt is the cell containing v, or the closest one to it if it's outside the grid.
+func (g *navGrid) cellAt(v pixel.Vec) navCell {
+	x := int(math.Floor((v.X - g.bounds.Min.X) / navCellSize))
+	y := int(math.Floor((v.Y - g.bounds.Min.Y) / navCellSize))
+
+	return navCell{clampInt(x, 0, g.cols-1), clampInt(y, 0, g.rows-1)}
+}
+
+func (g *navGrid) center(c navCell) pixel.Vec {
+	return g.bounds.Min.Add(pixel.V(float64(c.x)+0.5, float64(c.y)+0.5).Scaled(navCellSize))
+}
+
+func (g *navGrid) index(c navCell) int {
+	return c.y*g.cols + c.x
+}
+
+func (g *navGrid) inside(c navCell) bool {
+	return c.x >= 0 && c.y >= 0 && c.x < g.cols && c.y < g.rows
+}
+
+func (g *navGrid) free(c navCell) bool {
+	return g.inside(c) && !g.blocked[g.index(c)]
+}
+
+// lineOfSight reports whether an agent can move in a straight line from a to b without entering a blocked cell.
+func (g *navGrid) lineOfSight(a, b pixel.Vec) bool {
+	d := b.Sub(a)
+	steps := int(math.Ceil(d.Len() / (navCellSize / 2)))
+
+	for i := 1; i <= steps; i++ {
+		if !g.free(g.cellAt(a.Add(d.Scaled(float64(i) / float64(steps))))) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// navNeighbours are the offsets to the cells around a cell, and the cost of moving to them
+var navNeighbours = []struct {
+	dx, dy int
+	cost   float64
+}{
+	{1, 0, 1}, {-1, 0, 1}, {0, 1, 1}, {0, -1, 1},
+	{1, 1, math.Sqrt2}, {1, -1, math.Sqrt2}, {-1, 1, math.Sqrt2}, {-1, -1, math.Sqrt2},
+}
+
+// canStep reports whether an agent can move from a cell to its neighbour. moving diagonally needs both of the cells
+// beside the move to be free, so agents don't cut corners.
+func (g *navGrid) canStep(c navCell, dx, dy int) bool {
+	if !g.free(navCell{c.x + dx, c.y + dy}) {
+		return false
+	}
+
+	if dx != 0 && dy != 0 {
+		return g.free(navCell{c.x + dx, c.y}) && g.free(navCell{c.x, c.y + dy})
+	}
+
+	return true
+}
+
+type navNode struct {
+	cell     navCell
+	priority float64
+}
+
+// navQueue is a priority queue of cells to explore, lowest priority first
+type navQueue []navNode
+
+func (q navQueue) Len() int            { return len(q) }
+func (q navQueue) Less(i, j int) bool  { return q[i].priority < q[j].priority }
+func (q navQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *navQueue) Push(x interface{}) { *q = append(*q, x.(navNode)) }
+
+func (q *navQueue) Pop() interface{} {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return n
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+
+	if v > max {
+		return max
+	}
+
+	return v
+}
@@ -8,7 +8,6 @@ import (
 	"golang.org/x/image/colornames"
 	"golang.org/x/image/font/basicfont"
 	"image/color"
-	"time"
 )
 
 type score struct {
@@ -18,11 +17,12 @@ type score struct {
 	multiplierPos pixel.Vec
 	scorePos      pixel.Vec
 
-	startTime          time.Time
-	timeWindow, onBeat bool
+	timeWindow bool
 
-	audio   *audio
-	audioCh chan struct{}
+	// judgements counts every judgement this run
+	judgements [numJudgements]int
+
+	audio *audio
 
 	atlas *text.Atlas
 
@@ -33,6 +33,52 @@ func (s *score) incrementScore(by float64) {
 	s.score += by
 }
 
+// judgeShot judges a shot fired now against the beat, counting it and applying it to the multiplier progress.
+func (s *score) judgeShot() judgementTier {
+	tier := judge(s.audio.clock.beatOffset() - userSettings.audioOffset())
+
+	s.judgements[tier.judgement]++
+
+	if characterIsOutside {
+		s.progress(tier.progress)
+	}
+
+	return tier
+}
+
+// judgeDodge reports whether a dodge started now is on the beat. dodges aren't counted in the judgements.
+func (s *score) judgeDodge() bool {
+	return judge(s.audio.clock.beatOffset()-userSettings.audioOffset()).judgement >= great
+}
+
+// progress moves the multiplier towards the next (or previous) multiplier.
+func (s *score) progress(by int) {
+	switch {
+	case by > 0:
+		s.increment += by
+
+		if s.increment >= 8 {
+			s.multiplier++
+			s.increment = 0
+		}
+
+		if s.multiplier > 8 {
+			s.multiplier = 8
+		}
+	case by < 0:
+		s.increment += by
+
+		if s.increment <= 0 {
+			s.multiplier--
+			s.increment = 8
+		}
+
+		if s.multiplier < 1 {
+			s.multiplier = 1
+		}
+	}
+}
+
 func (s *score) setMultiplier(multiplier int) {
 	if multiplier < 1 {
 		return
@@ -66,10 +112,34 @@ func (s *score) draw(win *pixelgl.Window, canvas *pixelgl.Canvas) {
 	scorePos.X = canvas.Bounds().Max.X - 40
 
 	scoreText.Draw(win, pixel.IM.Moved(scorePos))
+
+	if ded {
+		s.drawStats(win, canvas)
+	}
+}
+
+// drawStats shows the number of each judgement for the run.
+func (s *score) drawStats(win *pixelgl.Window, canvas *pixelgl.Canvas) {
+	statsText := text.New(pixel.ZV, s.atlas)
+	statsText.Color = s.color
+
+	for j := perfect; j >= miss; j-- {
+		_, err := fmt.Fprintf(statsText, "%-8s%d\n", j, s.judgements[j])
+
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	statsPos := canvas.Bounds().Min
+	statsPos.X += 20
+	statsPos.Y = canvas.Bounds().Max.Y - 40
+
+	statsText.Draw(win, pixel.IM.Moved(statsPos))
 }
 
 func (s *score) changeTrack(track *audio) {
-	err := track.load()
+	err := track.load(gameFS)
 
 	if err != nil {
 		panic(err)
@@ -78,7 +148,7 @@ func (s *score) changeTrack(track *audio) {
 	s.audio.cfn()
 
 	s.audio = track
-	s.audio.play(s.audioCh)
+	s.audio.play()
 }
 
 func (s *score) init() {
@@ -90,80 +160,29 @@ func (s *score) init() {
 
 	s.atlas = text.NewAtlas(
 		basicfont.Face7x13,
-		[]rune{'0', '1', '2', '3', '4', '5', '6', '7', '8', '9', 'x', '-'},
+		text.ASCII,
 	)
 
-	s.audioCh = make(chan struct{})
+	// change audio track here
+	s.audio = tracks[defaultTrack]
 
 	go func() {
-
-		// change audio track here
-		s.audio = acidJazzAudio
-
-		err := s.audio.load()
+		err := s.audio.load(gameFS)
 
 		if err != nil {
 			panic(err)
 		}
 
 		// start the current track
-		go s.audio.play(s.audioCh)
-	}()
-
-	go func() {
-		select {
-		case <-s.audioCh:
-			//s.startTime = t
-			s.startTime = time.Now().Add(time.Nanosecond * 27000000)
-			return
-		}
+		go s.audio.play()
 	}()
-
 }
 
 func (s *score) update(dt float64) {
-	// If time is within 10ms of the bpm (from start time)
-	timeSince := (time.Now().UnixNano() - s.startTime.UnixNano() - int64(dt*1e+9)) % (int64(60000000000 / s.audio.bpm))
-	if timeSince <= 100000000 || timeSince >= 400000000 {
-		s.timeWindow = true
-	} else {
-		s.timeWindow = false
-	}
-
-	if win.JustPressed(pixelgl.MouseButtonLeft) {
-
-		if s.timeWindow {
-			s.onBeat = true
-
-			if characterIsOutside {
-				s.increment++
-
-				if s.increment >= 8 {
-					s.multiplier++
-					s.increment = 0
-				}
-
-				if s.multiplier > 8 {
-					s.multiplier = 8
-				}
-			}
-		} else {
-			s.onBeat = false
-
-			if characterIsOutside {
-				s.increment = s.increment - 2
-
-				if s.increment <= 0 {
-					s.multiplier--
-					s.increment = 8
-				}
-
-				if s.multiplier < 1 {
-					s.multiplier = 1
-				}
-			}
-		}
-	}
+	// the beat window is measured against what is currently audible, not the wall clock, and shifted so that it is
+	// seen at the same time as it is heard
+	offset := s.audio.clock.beatOffset() - userSettings.visualOffset()
+	s.timeWindow = offset >= -beatWindow() && offset <= beatWindow()
 
 	// @TODO colours for scores, perhaps a little animated multi tone stuff for big ones
 	switch s.multiplier {
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+	"golang.org/x/image/colornames"
+)
+
+const (
+	// scytheSwingArc is how far, in radians, a scythe swings in one attack. the swing is centred on the direction the
+	// enemy was aiming in when it started.
+	scytheSwingArc = 4.5
+
+	// scytheGrip is how far from the end of the scythe picture the enemy holds it, in pixels
+	scytheGrip = 20
+
+	// scytheBlade is how much of the scythe's reach, from its tip, hurts
+	scytheBlade = 0.5
+
+	// scytheSweepStep is the largest angle, in radians, between the blade positions checked along a swing
+	scytheSweepStep = 0.1
+)
+
+// scytheAngle is the direction the scythe points in, attackAngle radians into its swing.
+func (e *enemy) scytheAngle(attackAngle float64) float64 {
+	return e.aimAngle + scytheSwingArc/2 - attackAngle
+}
+
+// scytheReach is the length of the scythe, from where it's held to its tip.
+func (e *enemy) scytheReach() float64 {
+	return (e.scythe.Frame().W() - scytheGrip) * e.kind.Scale
+}
+
+// blade is the part of the scythe which hurts, when the enemy is at pos and the scythe points at angle.
+func (e *enemy) blade(pos pixel.Vec, angle float64) (from, to pixel.Vec) {
+	dir := pixel.V(1, 0).Rotated(angle)
+	reach := e.scytheReach()
+
+	return pos.Add(dir.Scaled(reach * (1 - scytheBlade))), pos.Add(dir.Scaled(reach))
+}
+
+// aim points the raised scythe at the target, while the enemy builds up to a swing.
+func (e *enemy) aim() {
+	e.aimAngle = e.target.Sub(e.rect.Center()).Angle()
+	e.attackAngle, e.prevAttackAngle = 0, 0
+}
+
+// swing moves the scythe on by dt, hurting the player if the blade passes through them. each swing only hurts once.
+func (e *enemy) swing(dt float64) {
+	e.prevAttackAngle = e.attackAngle
+	e.attackAngle += e.kind.Attack.Speed * dt
+
+	player := e.crowd.player
+
+	if e.swingHit || player == nil {
+		return
+	}
+
+	if e.sweepHits(e.prevAttackAngle, e.attackAngle, player.body.rect) {
+		e.swingHit = true
+
+		if player.body.invulnerable() {
+			player.body.dodged()
+			return
+		}
+
+		player.hurt(e.kind.Attack.Damage)
+	}
+}
+
+// sweepHits reports whether the blade passes through a rect as the scythe swings between two points in its swing.
+func (e *enemy) sweepHits(from, to float64, r pixel.Rect) bool {
+	steps := int(math.Ceil(math.Abs(to-from) / scytheSweepStep))
+
+	if steps < 1 {
+		steps = 1
+	}
+
+	for i := 0; i <= steps; i++ {
+		a, b := e.blade(e.rect.Center(), e.scytheAngle(from+(to-from)*float64(i)/float64(steps)))
+
+		if segmentIntersectsRect(a, b, r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// segmentIntersectsRect reports whether the line segment from a to b passes through r.
+func segmentIntersectsRect(a, b pixel.Vec, r pixel.Rect) bool {
+	r = r.Norm()
+	d := b.Sub(a)
+
+	// clip the segment against each pair of edges in turn
+	t0, t1 := 0.0, 1.0
+
+	for _, edge := range []struct{ p, q float64 }{
+		{-d.X, a.X - r.Min.X},
+		{d.X, r.Max.X - a.X},
+		{-d.Y, a.Y - r.Min.Y},
+		{d.Y, r.Max.Y - a.Y},
+	} {
+		if edge.p == 0 {
+			if edge.q < 0 {
+				return false
+			}
+
+			continue
+		}
+
+		t := edge.q / edge.p
+
+		if edge.p < 0 {
+			t0 = math.Max(t0, t)
+		} else {
+			t1 = math.Min(t1, t)
+		}
+
+		if t0 > t1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// drawScythe draws the scythe held by an enemy drawn at center, between its last two positions in the swing.
+func (e *enemy) drawScythe(t pixel.Target, center pixel.Vec, mask pixel.RGBA) {
+	angle := e.scytheAngle(e.prevAttackAngle + (e.attackAngle-e.prevAttackAngle)*frameAlpha)
+
+	m := pixel.IM.
+		Moved(pixel.V(e.scythe.Frame().W()/2-scytheGrip, 0)).
+		Scaled(pixel.ZV, e.kind.Scale).
+		Rotated(pixel.ZV, angle).
+		Moved(center)
+
+	e.scythe.DrawColorMask(t, m, mask)
+}
+
+// drawBlade shows the part of the scythe which hurts, for debugging.
+func (e *enemy) drawBlade(imd *imdraw.IMDraw) {
+	a, b := e.blade(e.rect.Center(), e.scytheAngle(e.attackAngle))
+
+	imd.Color = colornames.Orangered
+	imd.Push(a, b)
+	imd.Line(2)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// settings are stored per user in their config directory, e.g. ~/.config/beat-phaser/settings.json
+type settings struct {
+	// AudioOffsetMS is how late (in milliseconds) the player hits a beat they hear
+	AudioOffsetMS float64 `json:"audioOffsetMs"`
+
+	// VisualOffsetMS is how late (in milliseconds) the player hits a beat they see
+	VisualOffsetMS float64 `json:"visualOffsetMs"`
+}
+
+var userSettings = &settings{}
+
+func settingsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "beat-phaser", "settings.json"), nil
+}
+
+// loadSettings loads the user's settings, returning the defaults if none have been saved.
+func loadSettings() (*settings, error) {
+	s := &settings{}
+
+	path, err := settingsPath()
+
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *settings) save() error {
+	path, err := settingsPath()
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+
+	return enc.Encode(s)
+}
+
+func (s *settings) audioOffset() time.Duration {
+	return time.Duration(s.AudioOffsetMS * float64(time.Millisecond))
+}
+
+// visualOffset is how far the beat visuals need to be moved so that they are seen at the same time as the
+// beat is heard.
+func (s *settings) visualOffset() time.Duration {
+	return time.Duration((s.AudioOffsetMS - s.VisualOffsetMS) * float64(time.Millisecond))
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
-	"os"
-	"path/filepath"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/faiface/beep"
@@ -13,122 +17,200 @@ import (
 	"github.com/faiface/beep/vorbis"
 )
 
-var (
-	acidJazzAudio = &audio{
-		filepath: filepath.Join("audio", "tracks", "Kevin_MacLeod_-_AcidJazz.mp3"),
-		loop:     -1,
-		bpm:      110.724,
-		buffered: true,
-	}
+const (
+	defaultTrack = "acid-jazz"
+	deathTrack   = "night-on-the-docks"
+)
+
+// tracks are all of the tracks with a beatmap in audio/tracks, keyed by the beatmap name
+var tracks map[string]*audio
 
-	backedVibesAudio = &audio{
-		filepath: filepath.Join("audio", "tracks", "Kevin_MacLeod_Backed_Vibes_Clean.mp3"),
-		loop:     -1,
-		bpm:      102.230,
+// loadTracks loads every beatmap in dir, keyed by the name of the beatmap file.
+func loadTracks(fsys fs.FS, dir string) (map[string]*audio, error) {
+	paths, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+
+	if err != nil {
+		return nil, err
 	}
 
-	nightOnTheDocksAudio = &audio{
-		filepath: filepath.Join("audio", "tracks", "Kevin_MacLeod_-_Night_on_the_Docks_-_Sax.mp3"),
-		loop:     -1,
-		bpm:      139.658,
+	tracks := make(map[string]*audio)
+
+	for _, p := range paths {
+		b, err := loadBeatmap(fsys, p)
+
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(path.Base(p), path.Ext(p))
+
+		tracks[name] = &audio{
+			name:     name,
+			filepath: b.Audio,
+			loop:     -1,
+			beatmap:  b,
+			buffered: b.Buffered,
+		}
 	}
-)
 
-type soundEffect struct {
-	filePath string
-	format   beep.Format
-	decoded  beep.StreamSeekCloser
+	return tracks, nil
 }
 
-func (s *soundEffect) load() {
-	f1, err := os.Open(s.filePath)
+// trackNames is the names of all tracks, in order.
+func trackNames() []string {
+	var names []string
 
-	if err != nil {
-		panic(err)
+	for name := range tracks {
+		names = append(names, name)
 	}
 
-	s1, format, err := vorbis.Decode(f1)
+	sort.Strings(names)
 
-	if err != nil {
-		panic(err)
-	}
+	return names
+}
 
-	s.decoded = s1
-	s.format = format
+type soundEffect struct {
+	asset assetRequest
+	buf   *beep.Buffer
 }
 
+func (s *soundEffect) load() error {
+	var err error
+
+	s.buf, err = assets.sound(s.asset)
+
+	return err
+}
+
+// play plays the sound effect from the start. the same effect can be played over itself.
 func (s *soundEffect) play() {
 
 	// @TODO volume sliders with effects/music control
 	// Base is 2 for human-natural, 10 would be decibels
 	effectVolume := effects.Volume{
-		Streamer: s.decoded,
+		Streamer: s.buf.Streamer(0, s.buf.Len()),
 		Base:     2,
 		Volume:   -3,
 		Silent:   true,
 	}
 
-	speaker.Play(beep.Seq(&effectVolume, beep.Callback(func() {
-		err := s.decoded.Seek(0)
+	speaker.Play(&effectVolume)
+}
+
+// loadSound decodes an ogg or mp3 file into memory.
+func loadSound(fsys fs.FS, name string) (*beep.Buffer, error) {
+	f, err := fsys.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	streamer, format, err := decode(name, f)
 
-		if err != nil {
-			panic(err)
-		}
-	})))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	defer streamer.Close()
+
+	buf := beep.NewBuffer(format)
+	buf.Append(streamer)
+
+	if err := streamer.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return buf, nil
+}
+
+// decode decodes an mp3 or ogg file, choosing the decoder from the extension of name.
+func decode(name string, rc io.ReadCloser) (beep.StreamSeekCloser, beep.Format, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".ogg":
+		return vorbis.Decode(rc)
+	default:
+		return mp3.Decode(rc)
+	}
 }
 
 type audio struct {
+	name     string
 	filepath string
 	loop     int
-	bpm      float64
+	beatmap  *beatmap
 	buffered bool
 
 	buf      *beep.Buffer
 	streamer beep.StreamSeeker
 	format   beep.Format
-	file     *os.File
+	file     fs.File
+
+	// clock tracks the playback position of streamer
+	clock beatClock
 
 	ctx context.Context
 	cfn func()
 }
 
-func (a *audio) load() error {
+// load opens the track in fsys, decoding the whole of it if it's buffered.
+func (a *audio) load(fsys fs.FS) error {
 	var err error
-	a.file, err = os.Open(a.filepath)
-
-	if err != nil {
-		return err
-	}
-
-	s1, format, err := mp3.Decode(a.file)
-
-	if err != nil {
-		return err
-	}
 
 	if a.buffered {
-		buf := beep.NewBuffer(format)
-		buf.Append(s1)
+		// buffered tracks are decoded once, and kept for as long as the game runs
+		if a.buf == nil {
+			a.buf, err = assets.sound(soundAsset(a.filepath))
+
+			if err != nil {
+				return err
+			}
+		}
 
-		a.buf = buf
 		a.streamer = a.buf.Streamer(0, a.buf.Len())
 		a.format = a.buf.Format()
 	} else {
-		a.streamer = s1
-		a.format = format
+		a.file, err = fsys.Open(a.filepath)
+
+		if err != nil {
+			return err
+		}
+
+		a.streamer, a.format, err = decode(a.filepath, a.file)
+
+		if err != nil {
+			return err
+		}
 	}
 
+	a.clock.reset(a.streamer, a.format.SampleRate, a.beatmap)
+
 	a.ctx, a.cfn = context.WithCancel(context.Background())
 
 	return nil
 }
 
-func (a *audio) play(ch chan struct{}) {
-	err := speaker.Init(a.format.SampleRate, a.format.SampleRate.N(time.Second/10))
+// initSpeaker initialises the speaker (stopping anything already playing), returning the latency of its buffer.
+func initSpeaker(sampleRate beep.SampleRate) (time.Duration, error) {
+	bufferSize := sampleRate.N(time.Second / 10)
+
+	err := speaker.Init(sampleRate, bufferSize)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return sampleRate.D(bufferSize), nil
+}
+
+func (a *audio) play() {
+	latency, err := initSpeaker(a.format.SampleRate)
 
 	if err != nil {
 		panic(err)
 	}
+
+	a.clock.setLatency(latency)
 loop:
 	playing := make(chan struct{})
 
@@ -139,7 +221,7 @@ loop:
 	}
 
 	ctrl := &effects.Volume{
-		Streamer: beep.Loop(-1, a.streamer),
+		Streamer: beep.Loop(-1, &a.clock),
 		Base:     2,
 		Volume:   -3,
 		Silent:   false,
@@ -148,9 +230,6 @@ loop:
 	speaker.Play(beep.Seq(ctrl, beep.Callback(func() {
 		close(playing)
 	})))
-	go func() {
-		ch <- struct{}{}
-	}()
 
 	for {
 		select {
@@ -167,6 +246,9 @@ loop:
 }
 
 func (a *audio) close() error {
+	if a.file == nil {
+		return nil
+	}
 
 	return a.file.Close()
 }
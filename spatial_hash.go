@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+
+	"github.com/faiface/pixel"
+)
+
+type hashCell struct {
+	x, y int
+}
+
+// spatialHash buckets collidables into a uniform grid of cells, so that only collidables sharing a cell need to be
+// checked against each other.
+type spatialHash struct {
+	cellSize float64
+	cells    map[hashCell][]Collidable
+
+	// visited is reused by query to avoid visiting a collidable in more than one cell twice
+	visited map[Collidable]bool
+}
+
+func newSpatialHash(cellSize float64) *spatialHash {
+	return &spatialHash{
+		cellSize: cellSize,
+		cells:    make(map[hashCell][]Collidable),
+		visited:  make(map[Collidable]bool),
+	}
+}
+
+// cellRange is the range of cells covered by a rect.
+func (h *spatialHash) cellRange(r pixel.Rect) (min, max hashCell) {
+	r = r.Norm()
+
+	min = hashCell{int(math.Floor(r.Min.X / h.cellSize)), int(math.Floor(r.Min.Y / h.cellSize))}
+	max = hashCell{int(math.Floor(r.Max.X / h.cellSize)), int(math.Floor(r.Max.Y / h.cellSize))}
+
+	return min, max
+}
+
+// clear removes every collidable, keeping the cells' storage for reuse.
+func (h *spatialHash) clear() {
+	for k, cell := range h.cells {
+		h.cells[k] = cell[:0]
+	}
+}
+
+// insert adds a collidable to every cell covered by r.
+func (h *spatialHash) insert(c Collidable, r pixel.Rect) {
+	min, max := h.cellRange(r)
+
+	for x := min.x; x <= max.x; x++ {
+		for y := min.y; y <= max.y; y++ {
+			k := hashCell{x, y}
+			h.cells[k] = append(h.cells[k], c)
+		}
+	}
+}
+
+// query calls fn once for each collidable sharing a cell with r.
+func (h *spatialHash) query(r pixel.Rect, fn func(Collidable)) {
+	for c := range h.visited {
+		delete(h.visited, c)
+	}
+
+	min, max := h.cellRange(r)
+
+	for x := min.x; x <= max.x; x++ {
+		for y := min.y; y <= max.y; y++ {
+			for _, c := range h.cells[hashCell{x, y}] {
+				if h.visited[c] {
+					continue
+				}
+
+				h.visited[c] = true
+				fn(c)
+			}
+		}
+	}
+}
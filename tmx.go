@@ -0,0 +1,504 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"image"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// importTMX builds a level from a Tiled map. the map is made of:
+//
+//   - image layers, and tile objects from image collection tilesets, which become the level's layers. set the bool
+//     property topLayer to draw one over the characters, or animLayer (with the frameWidth property) to animate
+//     it with the Norm animation of its sheet.
+//   - objects with the class wall, which become walls of the layer above them in the map.
+//   - points with the class light, with optional color, radius, angle, spread and topLayer properties.
+//   - the playerSpawn point, the outside and door rects, spawnZone rects and advert points (with a width property).
+//
+// Tiled's y axis points down, so the map is flipped, and moved by the map's originX and originY properties.
+func importTMX(fsys fs.FS, name string) (*level, error) {
+	m, err := readTMX(fsys, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &tmxImporter{
+		fsys:    fsys,
+		dir:     path.Dir(name),
+		originX: m.Properties.float("originX", 0),
+		originY: m.Properties.float("originY", 0),
+		level:   &level{},
+	}
+
+	for _, ts := range m.Tilesets {
+		if err := imp.addTileset(ts); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	sort.Slice(imp.tilesets, func(i, j int) bool {
+		return imp.tilesets[i].FirstGID < imp.tilesets[j].FirstGID
+	})
+
+	if err := imp.addLayers(m.Layers, 0, 0); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	if err := imp.level.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return imp.level, nil
+}
+
+type tmxMap struct {
+	Orientation string        `xml:"orientation,attr"`
+	Properties  tmxProperties `xml:"properties>property"`
+	Tilesets    []tmxTileset  `xml:"tileset"`
+
+	// Layers keeps every kind of layer in the order they're drawn
+	Layers []tmxLayer `xml:",any"`
+}
+
+type tmxTileset struct {
+	FirstGID int    `xml:"firstgid,attr"`
+	Source   string `xml:"source,attr"`
+	Name     string `xml:"name,attr"`
+
+	// Image is set for tilesets made from a single image, which aren't supported
+	Image *tmxImage `xml:"image"`
+	Tiles []tmxTile `xml:"tile"`
+
+	// dir is where the tileset's images are relative to
+	dir string
+}
+
+type tmxTile struct {
+	ID         int           `xml:"id,attr"`
+	Image      *tmxImage     `xml:"image"`
+	Properties tmxProperties `xml:"properties>property"`
+}
+
+type tmxImage struct {
+	Source string  `xml:"source,attr"`
+	Width  float64 `xml:"width,attr"`
+	Height float64 `xml:"height,attr"`
+}
+
+// tmxLayer is any kind of layer: a tile layer, image layer, object group or group of layers.
+type tmxLayer struct {
+	XMLName    xml.Name
+	Name       string        `xml:"name,attr"`
+	Visible    string        `xml:"visible,attr"`
+	OffsetX    float64       `xml:"offsetx,attr"`
+	OffsetY    float64       `xml:"offsety,attr"`
+	Properties tmxProperties `xml:"properties>property"`
+
+	Image   *tmxImage   `xml:"image"`
+	Objects []tmxObject `xml:"object"`
+	Layers  []tmxLayer  `xml:",any"`
+}
+
+type tmxObject struct {
+	Name       string        `xml:"name,attr"`
+	Type       string        `xml:"type,attr"`
+	Class      string        `xml:"class,attr"`
+	GID        uint32        `xml:"gid,attr"`
+	X          float64       `xml:"x,attr"`
+	Y          float64       `xml:"y,attr"`
+	Width      float64       `xml:"width,attr"`
+	Height     float64       `xml:"height,attr"`
+	Visible    string        `xml:"visible,attr"`
+	Properties tmxProperties `xml:"properties>property"`
+}
+
+// class is the object's class, which was called its type before Tiled 1.9
+func (o tmxObject) class() string {
+	if o.Class != "" {
+		return o.Class
+	}
+
+	return o.Type
+}
+
+type tmxProperty struct {
+	Name  string `xml:"name,attr"`
+	Type  string `xml:"type,attr"`
+	Value string `xml:"value,attr"`
+
+	// Text is used instead of Value for multiline strings
+	Text string `xml:",chardata"`
+}
+
+type tmxProperties []tmxProperty
+
+func (p tmxProperties) get(name string) (string, bool) {
+	for _, prop := range p {
+		if prop.Name == name {
+			if prop.Value == "" {
+				return prop.Text, true
+			}
+
+			return prop.Value, true
+		}
+	}
+
+	return "", false
+}
+
+func (p tmxProperties) float(name string, def float64) float64 {
+	if v, ok := p.get(name); ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+
+	return def
+}
+
+func (p tmxProperties) bool(name string) bool {
+	v, _ := p.get(name)
+
+	return v == "true"
+}
+
+// color returns a colour property as #rrggbb. Tiled stores colours as #aarrggbb.
+func (p tmxProperties) color(name, def string) string {
+	v, ok := p.get(name)
+
+	if !ok || v == "" {
+		return def
+	}
+
+	if len(v) == 9 {
+		return "#" + v[3:]
+	}
+
+	return v
+}
+
+func readTMX(fsys fs.FS, name string) (*tmxMap, error) {
+	f, err := fsys.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var m tmxMap
+
+	if err := xml.NewDecoder(f).Decode(&m); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	if m.Orientation != "orthogonal" {
+		return nil, fmt.Errorf("%s: only orthogonal maps are supported, not %s", name, m.Orientation)
+	}
+
+	return &m, nil
+}
+
+type tmxImporter struct {
+	fsys             fs.FS
+	dir              string
+	originX, originY float64
+	tilesets         []tmxTileset
+	level            *level
+}
+
+// vec converts a point in the map to the level's coordinates
+func (imp *tmxImporter) vec(x, y float64) levelVec {
+	return levelVec{x - imp.originX, imp.originY - y}
+}
+
+// rect converts a rect in the map, from its top left corner, to the level's coordinates
+func (imp *tmxImporter) rect(x, y, w, h float64) levelRect {
+	min, max := imp.vec(x, y), imp.vec(x+w, y+h)
+
+	return levelRect{min[0], min[1], max[0], max[1]}
+}
+
+// addTileset loads an external tileset if it has a source, and keeps it for looking up tile objects.
+func (imp *tmxImporter) addTileset(ts tmxTileset) error {
+	ts.dir = imp.dir
+
+	if ts.Source != "" {
+		name := path.Join(imp.dir, ts.Source)
+		f, err := imp.fsys.Open(name)
+
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		firstGID := ts.FirstGID
+
+		if err := xml.NewDecoder(f).Decode(&ts); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		ts.FirstGID = firstGID
+		ts.dir = path.Dir(name)
+	}
+
+	imp.tilesets = append(imp.tilesets, ts)
+
+	return nil
+}
+
+// tile finds the tile with a global ID, ignoring the flags Tiled stores in the top bits when a tile is flipped.
+func (imp *tmxImporter) tile(gid uint32) (tmxTile, string, error) {
+	gid &^= 0xf0000000
+
+	for i := len(imp.tilesets) - 1; i >= 0; i-- {
+		ts := imp.tilesets[i]
+
+		if int(gid) < ts.FirstGID {
+			continue
+		}
+
+		if ts.Image != nil {
+			return tmxTile{}, "", fmt.Errorf("tileset %s: only image collection tilesets are supported", ts.Name)
+		}
+
+		id := int(gid) - ts.FirstGID
+
+		for _, t := range ts.Tiles {
+			if t.ID == id && t.Image != nil {
+				return t, ts.dir, nil
+			}
+		}
+
+		return tmxTile{}, "", fmt.Errorf("tileset %s: no image for tile %d", ts.Name, id)
+	}
+
+	return tmxTile{}, "", fmt.Errorf("no tileset for tile %d", gid)
+}
+
+func (imp *tmxImporter) addLayers(layers []tmxLayer, offsetX, offsetY float64) error {
+	for _, l := range layers {
+		if l.Visible == "0" {
+			continue
+		}
+
+		x, y := offsetX+l.OffsetX, offsetY+l.OffsetY
+
+		var err error
+
+		switch l.XMLName.Local {
+		case "imagelayer":
+			if l.Image == nil || l.Image.Source == "" {
+				continue
+			}
+
+			err = imp.addImage(l.Name, *l.Image, imp.dir, x, y, l.Properties)
+		case "objectgroup":
+			err = imp.addObjects(l, x, y)
+		case "group":
+			err = imp.addLayers(l.Layers, x, y)
+		case "layer":
+			err = fmt.Errorf("layer %s: tile layers aren't supported, use image layers or tile objects", l.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addImage adds a layer drawing an image, with its top left corner at x, y.
+func (imp *tmxImporter) addImage(name string, img tmxImage, dir string, x, y float64, props tmxProperties) error {
+	file := path.Join(dir, img.Source)
+
+	layer := levelLayer{
+		Z: zBottom,
+	}
+
+	var err error
+
+	layer.Image, err = roomImageName(file)
+
+	if err != nil {
+		return fmt.Errorf("layer %s: %v", name, err)
+	}
+
+	w, h := img.Width, img.Height
+
+	if w == 0 || h == 0 {
+		w, h, err = imageSize(imp.fsys, file)
+
+		if err != nil {
+			return fmt.Errorf("layer %s: %v", name, err)
+		}
+	}
+
+	switch {
+	case props.bool("animLayer"):
+		layer.Z = zAnim
+		layer.FrameWidth = props.float("frameWidth", 0)
+
+		// only one frame of the sheet is drawn at a time
+		w = layer.FrameWidth
+	case props.bool("topLayer"):
+		layer.Z = zTop
+	}
+
+	// sprites are drawn around their centre
+	layer.Offset = imp.vec(x+w/2, y+h/2)
+
+	imp.level.Layers = append(imp.level.Layers, layer)
+
+	return nil
+}
+
+func (imp *tmxImporter) addObjects(group tmxLayer, offsetX, offsetY float64) error {
+	for _, o := range group.Objects {
+		if o.Visible == "0" {
+			continue
+		}
+
+		x, y := offsetX+o.X, offsetY+o.Y
+
+		if o.GID != 0 {
+			tile, dir, err := imp.tile(o.GID)
+
+			if err != nil {
+				return fmt.Errorf("object %s: %v", o.Name, err)
+			}
+
+			img := *tile.Image
+
+			if o.Width != 0 && o.Height != 0 {
+				img.Width, img.Height = o.Width, o.Height
+			}
+
+			// object properties override the tile's
+			props := append(append(tmxProperties{}, o.Properties...), tile.Properties...)
+
+			// tile objects are positioned by their bottom left corner
+			if err := imp.addImage(o.Name, img, dir, x, y-img.Height, props); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		switch o.class() {
+		case "wall":
+			if len(imp.level.Layers) == 0 {
+				return fmt.Errorf("wall %s: walls must be above a layer in the map", o.Name)
+			}
+
+			layer := &imp.level.Layers[len(imp.level.Layers)-1]
+			layer.Walls = append(layer.Walls, imp.rect(x, y, o.Width, o.Height))
+		case "light":
+			z := zBottom
+
+			if o.Properties.bool("topLayer") {
+				z = zTop
+			}
+
+			imp.level.Lights = append(imp.level.Lights, levelLight{
+				Color:  o.Properties.color("color", "#e8eb23"),
+				Point:  imp.vec(x, y),
+				Angle:  o.Properties.float("angle", -math.Pi/2),
+				Radius: o.Properties.float("radius", 400),
+				Spread: o.Properties.float("spread", math.Pi/math.E),
+				Z:      z,
+			})
+		case "playerSpawn":
+			imp.level.PlayerSpawn = imp.vec(x, y)
+		case "outside":
+			imp.level.Outside = imp.rect(x, y, o.Width, o.Height)
+		case "door":
+			imp.level.Door = imp.rect(x, y, o.Width, o.Height)
+		case "spawnZone":
+			imp.level.SpawnZones = append(imp.level.SpawnZones, imp.rect(x, y, o.Width, o.Height))
+		case "advert":
+			imp.level.Adverts = append(imp.level.Adverts, levelAdvert{
+				Pos:   imp.vec(x, y),
+				Width: int(o.Properties.float("width", 45)),
+			})
+		}
+	}
+
+	return nil
+}
+
+// roomImageName finds the name a level uses for an image, which must be a png in images/world/rooms.
+func roomImageName(name string) (string, error) {
+	const rooms = "images/world/rooms/"
+
+	if !strings.HasPrefix(name, rooms) || path.Ext(name) != ".png" {
+		return "", fmt.Errorf("image %s must be a png in %s", name, rooms)
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(name, rooms), ".png"), nil
+}
+
+func imageSize(fsys fs.FS, name string) (w, h float64, err error) {
+	f, err := fsys.Open(name)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer f.Close()
+
+	config, _, err := image.DecodeConfig(f)
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return float64(config.Width), float64(config.Height), nil
+}
+
+// importCommand implements `beat-phaser import [-o level.json] <map.tmx>`, which converts a Tiled map to a level.
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	out := flags.String("o", "", "write the level to this file instead of stdout")
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: beat-phaser import [-o level.json] <map.tmx>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	// maps are imported from the working directory, which should be the repo root so images can be found
+	name := filepath.ToSlash(filepath.Clean(flags.Arg(0)))
+
+	if !fs.ValidPath(name) {
+		return fmt.Errorf("%s: the map must be inside the working directory", flags.Arg(0))
+	}
+
+	l, err := importTMX(os.DirFS("."), name)
+
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(*out, l)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestImportTMX(t *testing.T) {
+	l, err := importTMX(os.DirFS("."), "testdata/level.tmx")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(l.Layers) != 1 {
+		t.Fatalf("imported %d layers, want 1", len(l.Layers))
+	}
+
+	layer := l.Layers[0]
+
+	// the map is flipped about originY, and layers are positioned by their centre
+	if layer.Image != "room1" || layer.Z != zBottom || layer.Offset != (levelVec{100, 950}) {
+		t.Errorf("layer = %+v, want room1 at the bottom, centred on [100, 950]", layer)
+	}
+
+	if want := []levelRect{{10, 980, 40, 940}}; !reflect.DeepEqual(layer.Walls, want) {
+		t.Errorf("walls = %v, want %v", layer.Walls, want)
+	}
+
+	if len(l.Lights) != 1 || l.Lights[0].Color != "#ff0000" || l.Lights[0].Point != (levelVec{100, 990}) {
+		t.Errorf("lights = %+v, want a red light at [100, 990]", l.Lights)
+	}
+
+	if l.PlayerSpawn != (levelVec{50, 940}) {
+		t.Errorf("player spawn = %v, want [50, 940]", l.PlayerSpawn)
+	}
+
+	if l.Outside != (levelRect{0, 1000, 200, 900}) {
+		t.Errorf("outside = %v, want [0, 1000, 200, 900]", l.Outside)
+	}
+
+	if want := []levelRect{{0, 1000, 100, 900}}; !reflect.DeepEqual(l.SpawnZones, want) {
+		t.Errorf("spawn zones = %v, want %v", l.SpawnZones, want)
+	}
+}
+
+func TestImportCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "level.json")
+
+	if err := importCommand([]string{"-o", out, "testdata/level.tmx"}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	var l level
+
+	if err := json.NewDecoder(f).Decode(&l); err != nil {
+		t.Fatalf("the level written isn't valid json: %v", err)
+	}
+
+	if len(l.Layers) != 1 || len(l.SpawnZones) != 1 {
+		t.Errorf("wrote %d layers and %d spawn zones, want 1 of each", len(l.Layers), len(l.SpawnZones))
+	}
+}
+
+func TestImportCommandUsage(t *testing.T) {
+	if err := importCommand(nil); !errors.Is(err, errUsage) {
+		t.Errorf("import without a map returned %v, want errUsage", err)
+	}
+}
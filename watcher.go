@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// devMode reloads assets and the level when they change on disk
+var devMode bool
+
+// watchInterval is how often the watcher checks for changes
+const watchInterval = time.Second / 2
+
+// watcher polls directories for changed files. polling is slower than asking the OS, but it works everywhere and
+// doesn't need any dependencies.
+type watcher struct {
+	dir   string
+	roots []string
+
+	files map[string]fileState
+
+	// changes receives the files which changed, as slash separated paths relative to dir
+	changes chan []string
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func newWatcher(dir string, roots ...string) *watcher {
+	w := &watcher{
+		dir:     dir,
+		roots:   roots,
+		changes: make(chan []string, 1),
+	}
+
+	w.files = w.scan()
+
+	return w
+}
+
+func (w *watcher) run() {
+	for range time.Tick(watchInterval) {
+		files := w.scan()
+
+		var changed []string
+
+		for name, state := range files {
+			if w.files[name] != state {
+				changed = append(changed, name)
+			}
+		}
+
+		w.files = files
+
+		if len(changed) > 0 {
+			w.changes <- changed
+		}
+	}
+}
+
+func (w *watcher) scan() map[string]fileState {
+	files := make(map[string]fileState)
+
+	for _, root := range w.roots {
+		_ = filepath.WalkDir(filepath.Join(w.dir, root), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+
+			info, err := d.Info()
+
+			if err != nil {
+				return nil
+			}
+
+			name, err := filepath.Rel(w.dir, p)
+
+			if err != nil {
+				return nil
+			}
+
+			files[filepath.ToSlash(name)] = fileState{modTime: info.ModTime(), size: info.Size()}
+
+			return nil
+		})
+	}
+
+	return files
+}
+
+// watchAssets starts watching the asset directory, if the game is in dev mode.
+func watchAssets() *watcher {
+	if !devMode {
+		return nil
+	}
+
+	if _, err := os.Stat(assetDir); err != nil {
+		return nil
+	}
+
+	w := newWatcher(assetDir, "images", "levels", archetypeDir)
+	go w.run()
+
+	return w
+}
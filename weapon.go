@@ -8,6 +8,14 @@ import (
 	"image/color"
 )
 
+const (
+	// laserThicknessDecay is how much thinner a laser gets every second
+	laserThicknessDecay = 2.88
+
+	// hitSplashSpeed is how quickly hit splashes grow, in pixels per second
+	hitSplashSpeed = 144
+)
+
 // handgun is a simple handgun-like weapon
 var handgun = &weapon{
 	speed: 700,
@@ -28,6 +36,8 @@ type weapon struct {
 	pringlePhaser *soundEffect
 }
 
+var phaserSound = soundAsset("audio/effects/pringle-phaser.ogg")
+
 func (w *weapon) init() {
 
 	if w.imdraw == nil {
@@ -36,18 +46,21 @@ func (w *weapon) init() {
 
 	if w.pringlePhaser == nil {
 		w.pringlePhaser = &soundEffect{
-			filePath: "audio/effects/pringle-phaser.ogg",
+			asset: phaserSound,
+		}
+
+		if err := w.pringlePhaser.load(); err != nil {
+			panic(err)
 		}
-		w.pringlePhaser.load()
 	}
 }
 
-func (w *weapon) fire(origin pixel.Vec, angle float64, color color.Color) {
+func (w *weapon) fire(origin pixel.Vec, angle float64, color color.Color, tier judgementTier) {
 	if !ded {
 		l := &laser{
 			color:     color,
 			velocity:  pixel.V(w.speed, 0).Rotated(angle),
-			damage:    float64(100 * playerScore.multiplier),
+			damage:    float64(100*playerScore.multiplier) * tier.damage,
 			pos:       origin,
 			thickness: 10,
 		}
@@ -72,27 +85,29 @@ func (w *weapon) draw(t pixel.Target) {
 	w.imdraw.Draw(t)
 }
 
-func (w *weapon) update(dt float64, characterPos pixel.Vec, parentVelocity pixel.Vec) {
+func (w *weapon) update(dt float64, characterPos pixel.Vec, parentVelocity pixel.Vec, dodging bool) {
 	w.parentPos = characterPos.Add(pixel.V(5, 0))
 
 	if parentVelocity.X < 0 && win.MousePosition().X < win.Bounds().Center().X {
 		w.matrix = w.matrix.Scaled(characterPos, -1).Moved(pixel.V(-10, 0))
 	}
 
-	if win.JustPressed(pixelgl.MouseButtonLeft) && !isDodging {
+	if justPressed(pixelgl.MouseButtonLeft) && !dodging && !ded {
 		a := getMouseAngleFromCenter()
 
+		tier := playerScore.judgeShot()
+
 		var c color.Color
 
-		if playerScore.onBeat {
+		if tier.judgement != miss {
 			c = playerScore.color
 		} else {
 			c = colornames.Black
 		}
 
-		w.fire(characterPos, a, c)
+		w.fire(characterPos, a, c, tier)
 
-		go w.pringlePhaser.play()
+		w.pringlePhaser.play()
 	}
 
 	for i := len(w.lasers) - 1; i >= 0; i-- {
@@ -114,7 +129,7 @@ func (w *weapon) update(dt float64, characterPos pixel.Vec, parentVelocity pixel
 	}
 
 	for i := len(w.hitSplashes) - 1; i >= 0; i-- {
-		w.hitSplashes[i].update()
+		w.hitSplashes[i].update(dt)
 
 		// If enemy is ded remove from slice
 		if w.hitSplashes[i].done {
@@ -130,7 +145,7 @@ type laser struct {
 
 	damage float64
 
-	pos pixel.Vec
+	pos, prevPos pixel.Vec
 
 	thickness     float64
 	numCollisions int
@@ -139,6 +154,8 @@ type laser struct {
 }
 
 func (l *laser) init() {
+	l.prevPos = l.pos
+
 	registerCollidable(l)
 }
 
@@ -150,19 +167,20 @@ func (l *laser) destroy() {
 }
 
 func (l *laser) HandleCollision(x Collidable, collisionTime float64, normal pixel.Vec) {
-	switch x.(type) {
-	case *laser, *character:
-		return
-	case *outsideDoor:
+	switch {
+	case x.Layer()&layerTrigger != 0:
 		l.destroy()
 		return
-	case *enemy:
+	case x.Layer()&layerEnemy != 0:
 		//@TODO create "hit" splash
 		l.splash = true
 		l.splashNormal = normal
 		l.destroy()
 	}
 
+	// move back to where the laser hit the surface, so it isn't left inside it
+	l.pos = l.pos.Sub(l.Vel().Scaled(1 - collisionTime))
+
 	if normal.X != 0 {
 		l.velocity.X = -l.velocity.X
 	}
@@ -174,6 +192,14 @@ func (l *laser) HandleCollision(x Collidable, collisionTime float64, normal pixe
 	l.numCollisions++
 }
 
+func (l *laser) Layer() collisionLayer {
+	return layerPlayerProjectile
+}
+
+func (l *laser) Mask() collisionLayer {
+	return layerStatic | layerTrigger | layerEnemy
+}
+
 func (l *laser) Vel() pixel.Vec {
 	return l.lastVelocity
 }
@@ -183,13 +209,14 @@ func (l *laser) Rect() pixel.Rect {
 }
 
 func (l *laser) update(dt float64) {
+	l.prevPos = l.pos
 	l.lastVelocity = l.velocity.Scaled(dt)
 
 	// move the position or expire the laser
 	l.pos = l.pos.Add(l.lastVelocity)
 
 	if l.thickness > 0 {
-		l.thickness = l.thickness - 0.02
+		l.thickness = l.thickness - laserThicknessDecay*dt
 	}
 }
 
@@ -197,7 +224,7 @@ func (l *laser) draw(imd *imdraw.IMDraw) {
 	imd.Color = l.color
 	imd.EndShape = imdraw.RoundEndShape
 
-	imd.Push(l.pos)
+	imd.Push(pixel.Lerp(l.prevPos, l.pos, frameAlpha))
 	imd.Polygon(l.thickness)
 }
 
@@ -216,8 +243,8 @@ func (h *hitSplash) init() {
 
 }
 
-func (h *hitSplash) update() {
-	h.x++
+func (h *hitSplash) update(dt float64) {
+	h.x += hitSplashSpeed * dt
 
 	if h.x > 10 {
 		h.done = true
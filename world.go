@@ -6,18 +6,19 @@ import (
 	"golang.org/x/image/colornames"
 	"image"
 	"image/color"
-	"math"
+	"io/fs"
 	"math/rand"
-	"os"
-	"path/filepath"
+	"path"
 )
 
 type world struct {
+	level *level
+
 	character   *character
 	enemies     *enemiesCollection
-	advert      *advert
-	advert1     *advert
+	adverts     []*advert
 	deadMessage *deadMessage
+	door        *outsideDoor
 
 	rain         *rain
 	rooms        []*room
@@ -28,206 +29,118 @@ type world struct {
 	mainScene *imdraw.IMDraw
 }
 
+// rainSpeed scales how far rain drops fall and drift each second
+const rainSpeed = 144
+
 var ded bool
 var healthDisplay float64
-var wallMidpointPositionVec = pixel.V(0, -50)
-var streetBoundingRect = pixel.R(-2100, -200, 2100, -2100)
-
-var streetCollidables = []*wall{
-	{rect: pixel.R(595, -750, 695, -400)},      // boxes
-	{rect: pixel.R(1975, -1970, 2100, -2100)},  // ..
-	{rect: pixel.R(-1025, -1880, -810, -1920)}, // ..
-	{rect: pixel.R(-1930, -750, -1805, -555)},  // end boxes
 
-	{rect: pixel.R(400, -885, 440, -900)},     // lamps
-	{rect: pixel.R(-480, -885, -445, -900)},   // ..
-	{rect: pixel.R(-1080, -885, -1035, -900)}, // ..
-	{rect: pixel.R(-1810, -885, -1765, -900)}, // ..
-	{rect: pixel.R(945, -885, 1000, -900)},    // ..
-	{rect: pixel.R(1815, -885, 1850, -900)},   // end lamps
-
-	{rect: pixel.R(160, -1690, 580, -1700)},     // car. brum brum
-	{rect: pixel.R(-2000, -1690, -1560, -1700)}, // blue car. brum brum
-
-	{rect: pixel.R(-235, -1030, 275, -900)}, // sign parts
-	{rect: pixel.R(50, -1030, 340, -1100)},  // ..
-	{rect: pixel.R(-180, -900, 250, -830)},  // ..
-	{rect: pixel.R(-140, -830, 70, -755)},   // end sign parts
-}
+// streetBoundingRect is the outside area of the level
+var streetBoundingRect pixel.Rect
 
 func (w *world) init() {
+	playerSpawnPos = w.level.PlayerSpawn.vec()
+	streetBoundingRect = w.level.Outside.rect()
+
 	w.character = &character{}
 	w.enemies = &enemiesCollection{}
 	w.character.init()
-	w.enemies.init()
 	w.mainScene = imdraw.New(nil)
 	w.weather = imdraw.New(nil)
 
-	w.advert = &advert{
-		pos:      pixel.V(-440, 155),
-		maxWidth: 45,
-	}
-	w.advert1 = &advert{
-		pos:      pixel.V(1240, -490),
-		maxWidth: 45,
+	for _, a := range w.level.Adverts {
+		advert := &advert{
+			pos:      a.Pos.vec(),
+			maxWidth: a.Width,
+		}
+		advert.init()
+
+		w.adverts = append(w.adverts, advert)
 	}
-	w.advert.init()
-	w.advert1.init()
 
 	w.deadMessage = &deadMessage{
 		pos: w.character.body.rect.Center().Add(pixel.V(-52, 50)),
 	}
 	w.deadMessage.init()
 
-	w.rooms = append(w.rooms, &room{
-		path: "/world-layer-background-bottom",
-		walls: []*wall{
-			// outside bounds
-			{rect: pixel.R(-700, -200, 700, -190)}, // bottom outermost wall
-			{rect: pixel.R(-710, 700, -700, -200)}, // left outermost wall
-			{rect: pixel.R(700, 340, 710, -540)},   // right outermost wall
-			{rect: pixel.R(700, 700, 710, 580)},
-			{rect: pixel.R(-700, 690, 1110, 700).Moved(wallMidpointPositionVec)}, // top outermost wall
-
-			// room divisors - top rooms
-			{rect: pixel.R(-10, 685, -5, 540).Moved(wallMidpointPositionVec)},    // hat room right wall
-			{rect: pixel.R(-230, 690, -165, 540).Moved(wallMidpointPositionVec)}, // outside/inside horizontal boundary (top)
-			{rect: pixel.R(-230, 310, -165, 200).Moved(wallMidpointPositionVec)}, // outside/inside horizontal boundary (bottom)
-			{rect: pixel.R(-10, 310, -5, 200).Moved(wallMidpointPositionVec)},    // hat room right wall (bottom of gap)
-
-			// room divisors - bottom rooms
-			{rect: pixel.R(-700, 190, 0, 200).Moved(wallMidpointPositionVec)},   // horizontal room boundary to first door
-			{rect: pixel.R(140, 190, 315, 200).Moved(wallMidpointPositionVec)},  // horizontal room boundary between doors
-			{rect: pixel.R(455, 190, 700, 200).Moved(wallMidpointPositionVec)},  // horizontal room boundary to outer wall
-			{rect: pixel.R(150, 190, 160, -140).Moved(wallMidpointPositionVec)}, // vertical boundary between bottom two rooms
-
-			{rect: pixel.R(260, 635, 0, 610)}, // kitchen top
-			{rect: pixel.R(0, 610, 50, 545)},  // kitchen side
-		},
-	})
-
-	w.rooms = append(w.rooms, &room{path: "/world-layer-background-top", topLayer: true, walls: []*wall{
-		{rect: pixel.R(-710, 250, -620, 150)}, // plant
-	}})
-	w.rooms = append(w.rooms, &room{path: "/world-layer-animation", animLayer: true, rate: 1.0 / 10})
-
-	// Wall with stairs layers
-	w.rooms = append(w.rooms, &room{path: "/wall-stairs-layer-background-bottom", offset: pixel.V(1400, 0), walls: []*wall{
-		{rect: pixel.R(910, 340, 925, -540)},   // stairs left hand wall
-		{rect: pixel.R(1110, 655, 1120, -540)}, // stair room right hand wall
-		{rect: pixel.R(930, -190, 1120, -200)}, // stair room base
-	}})
-	w.rooms = append(w.rooms, &room{path: "/wall-stairs-layer-background-top", offset: pixel.V(1400, 0), topLayer: true})
-
-	// Wall layers
-	w.rooms = append(w.rooms, &room{path: "/wall-layer-background-bottom", offset: pixel.V(-1400, 0)})
-	w.rooms = append(w.rooms, &room{path: "/wall-layer-background-top", offset: pixel.V(-1400, 0), topLayer: true, walls: []*wall{
-		{rect: pixel.R(-68, -140, 395, -200)}, // table with mirror
-		{rect: pixel.R(-430, 150, -135, 98)},  // table at bottom of bedrooms
-	}})
-
-	// Street layers
-	w.rooms = append(w.rooms, &room{path: "/street-layer-background-bottom", offset: pixel.V(0, -1400), walls: []*wall{
-		{rect: pixel.R(-2100, -540, 710, -550)}, // street top left
-		{rect: pixel.R(910, -540, 2100, -550)},  // street top right
-
-		{rect: pixel.R(streetBoundingRect.Min.X, streetBoundingRect.Max.Y, streetBoundingRect.Max.X, streetBoundingRect.Max.Y-10)}, // bottom
-		{rect: pixel.R(streetBoundingRect.Min.X, streetBoundingRect.Max.Y, streetBoundingRect.Min.X-10, streetBoundingRect.Min.Y)}, // left
-		{rect: pixel.R(streetBoundingRect.Max.X, streetBoundingRect.Max.Y, streetBoundingRect.Max.X+10, streetBoundingRect.Min.Y)}, // right
-	}})
-	w.rooms = append(w.rooms, &room{path: "/street-layer-background-top", offset: pixel.V(0, -1400), topLayer: true, walls: streetCollidables})
-
-	w.rooms = append(w.rooms, &room{path: "/street-right-layer-background-bottom", offset: pixel.V(1400, -1400)})
-	w.rooms = append(w.rooms, &room{path: "/street-right-layer-background-top", offset: pixel.V(1400, -1400), topLayer: true})
-
-	w.rooms = append(w.rooms, &room{path: "/street-left-layer-background-bottom", offset: pixel.V(-1400, -1400)})
-	w.rooms = append(w.rooms, &room{path: "/street-left-layer-background-top", offset: pixel.V(-1400, -1400), topLayer: true})
+	for _, layer := range w.level.Layers {
+		r := &room{
+			path:      layer.Image,
+			offset:    layer.Offset.vec(),
+			topLayer:  layer.Z == zTop,
+			animLayer: layer.Z == zAnim,
+
+			frameWidth: layer.FrameWidth,
+			asset:      layerAsset(layer),
+		}
+
+		for _, rect := range layer.Walls {
+			r.walls = append(r.walls, &wall{rect: rect.rect()})
+		}
+
+		w.rooms = append(w.rooms, r)
+	}
 
 	for _, room := range w.rooms {
 		room.init(room.path)
+
+		w.enemies.obstacles = append(w.enemies.obstacles, room.walls...)
+	}
+
+	for _, zone := range w.level.SpawnZones {
+		w.enemies.spawnZones = append(w.enemies.spawnZones, zone.rect())
 	}
 
+	w.enemies.director = newDirector(w.level.Waves, w.enemies.spawnZones)
+	w.enemies.player = w.character
+	w.enemies.init()
+
 	w.rain = &rain{
 		boundingRect: streetBoundingRect,
 	}
 
 	w.rain.init()
+	w.initLights()
 
-	colorYellow := color.RGBA{232, 235, 35, 255}
-
-	w.lights = []*colorLight{
-		{
-			color:  colorYellow,
-			point:  pixel.V(-667, 695),
-			angle:  -math.Pi / 2,
-			radius: 50,
-			spread: math.Pi / math.E,
-		},
-		{
-			color:  colorYellow,
-			point:  pixel.V(-553, 695),
-			angle:  -math.Pi / 2,
-			radius: 50,
-			spread: math.Pi / math.E,
-		},
-	}
+	w.door = &outsideDoor{rect: w.level.Door.rect()}
+	w.door.init()
+}
 
-	w.streetLights = []*colorLight{
-		// street lights
-		{
-			color:  colorYellow,
-			point:  pixel.V(-458, -708),
-			angle:  -math.Pi / 2,
-			radius: 400,
-			spread: math.Pi / math.E,
-		},
-		{
-			color:  colorYellow,
-			point:  pixel.V(419, -708),
-			angle:  -math.Pi / 2,
-			radius: 400,
-			spread: math.Pi / math.E,
-		},
-		{
-			color:  colorYellow,
-			point:  pixel.V(969, -708),
-			angle:  -math.Pi / 2,
-			radius: 400,
-			spread: math.Pi / math.E,
-		},
-		{
-			color:  colorYellow,
-			point:  pixel.V(1835, -708),
-			angle:  -math.Pi / 2,
-			radius: 400,
-			spread: math.Pi / math.E,
-		},
-		{
-			color:  colorYellow,
-			point:  pixel.V(-1058, -708),
-			angle:  -math.Pi / 2,
-			radius: 400,
-			spread: math.Pi / math.E,
-		},
-		{
-			color:  colorYellow,
-			point:  pixel.V(-1784, -708),
-			angle:  -math.Pi / 2,
-			radius: 400,
-			spread: math.Pi / math.E,
-		},
-	}
+// initLights (re)creates the level's lights.
+func (w *world) initLights() {
+	w.lights = nil
+	w.streetLights = nil
+
+	for _, l := range w.level.Lights {
+		c, _ := parseHexColor(l.Color) // validated on load
+
+		light := &colorLight{
+			color:  c,
+			point:  l.Point.vec(),
+			angle:  l.Angle,
+			radius: l.Radius,
+			spread: l.Spread,
+		}
 
-	for _, light := range w.lights {
 		light.init()
+
+		if l.Z == zTop {
+			w.streetLights = append(w.streetLights, light)
+		} else {
+			w.lights = append(w.lights, light)
+		}
 	}
+}
 
-	for _, light := range w.streetLights {
-		light.init()
+// layerAsset is the picture, or animation sheet, drawn by a level layer
+func layerAsset(l levelLayer) assetRequest {
+	dir := "images/world/rooms"
+
+	if l.Z == zAnim {
+		return sheetAsset(l.Image, l.FrameWidth, dir, "Norm")
 	}
 
-	od := outsideDoor{}
-	od.init()
+	return pictureAsset(path.Join(dir, l.Image))
 }
 
 func randomPointInRect(r pixel.Rect) pixel.Vec {
@@ -237,11 +150,13 @@ func randomPointInRect(r pixel.Rect) pixel.Vec {
 }
 
 func (w *world) update(dt float64) {
-	w.rain.update()
+	w.rain.update(dt)
 	w.character.update(dt)
-	w.enemies.update(dt, w.character.body.rect.Center())
-	w.advert.update(dt)
-	w.advert1.update(dt)
+	w.enemies.update(dt, w.character.body.rect.Center(), w.character.body.health/w.character.body.maxHealth)
+	for _, advert := range w.adverts {
+		advert.update(dt)
+	}
+
 	w.deadMessage.update(dt, w.character.body.rect.Center().Add(pixel.V(-52, 50)))
 
 	for _, room := range w.rooms {
@@ -249,6 +164,15 @@ func (w *world) update(dt float64) {
 			room.update(dt)
 		}
 	}
+
+	if ded {
+		healthDisplay -= 1.44 * dt
+		if healthDisplay <= 0 {
+			healthDisplay = 0
+		}
+	} else {
+		healthDisplay = 1
+	}
 }
 
 func (w *world) draw(t pixel.Target) {
@@ -287,15 +211,12 @@ func (w *world) draw(t pixel.Target) {
 	w.weather.Draw(t)
 
 	w.mainScene.Draw(t)
-	w.advert.draw(t)
-	w.advert1.draw(t)
 
-	if ded {
-		healthDisplay -= 0.01
-		if healthDisplay <= 0 {
-			healthDisplay = 0
-		}
+	for _, advert := range w.adverts {
+		advert.draw(t)
+	}
 
+	if ded {
 		imd := imdraw.New(nil)
 
 		imd.Color = colornames.Black
@@ -315,9 +236,6 @@ func (w *world) draw(t pixel.Target) {
 		w.character.draw(t)
 
 		w.deadMessage.draw(t)
-
-	} else {
-		healthDisplay = 1
 	}
 }
 
@@ -326,6 +244,35 @@ func (w *world) destroy() {
 	w.enemies.destroy()
 }
 
+// reloadAssets swaps reloaded assets into everything in the world using them.
+func (w *world) reloadAssets() {
+	w.character.body.reloadAssets()
+
+	for _, enemy := range w.enemies.enemies {
+		enemy.reloadAssets()
+	}
+
+	for _, room := range w.rooms {
+		room.reloadAssets()
+	}
+}
+
+// unload removes everything the level added to the world.
+func (w *world) unload() {
+	w.enemies.destroy()
+	w.character.destroy()
+
+	for _, room := range w.rooms {
+		for _, wall := range room.walls {
+			deregisterCollidable(wall)
+		}
+
+		assets.release(room.asset)
+	}
+
+	deregisterCollidable(w.door)
+}
+
 type rain struct {
 	positions    []pixel.Vec
 	boundingRect pixel.Rect
@@ -334,13 +281,15 @@ type rain struct {
 }
 
 func (r *rain) init() {
+	r.boundingRect = r.boundingRect.Norm()
+
 	for i := 0; i < 2000; i++ {
 		r.positions = append(r.positions, randomPointInRect(r.boundingRect))
 	}
 }
 
-func (r *rain) update() {
-	xRange := rand.Float64() - 0.5
+func (r *rain) update(dt float64) {
+	xRange := (rand.Float64() - 0.5) * rainSpeed * dt
 
 	if playerScore.timeWindow {
 		r.color = colornames.Blueviolet
@@ -350,11 +299,11 @@ func (r *rain) update() {
 
 	for i := range r.positions {
 
-		r.positions[i].Y -= rand.Float64() / (0.025 + rand.Float64()*0.04)
+		r.positions[i].Y -= rand.Float64() / (0.025 + rand.Float64()*0.04) * rainSpeed * dt
 		r.positions[i].X -= xRange
 
-		if r.positions[i].Y < r.boundingRect.Max.Y {
-			r.positions[i].Y = r.boundingRect.Min.Y
+		if r.positions[i].Y < r.boundingRect.Min.Y {
+			r.positions[i].Y = r.boundingRect.Max.Y
 		}
 	}
 }
@@ -374,6 +323,7 @@ type room struct {
 	path                string
 	drawnRoom           *imdraw.IMDraw
 	walls               []*wall
+	asset               assetRequest
 
 	img    pixel.Picture
 	imd    *imdraw.IMDraw
@@ -381,25 +331,21 @@ type room struct {
 	offset pixel.Vec
 
 	//anim
-	sheet         pixel.Picture
-	anims         map[string][]pixel.Rect
-	frame         pixel.Rect
-	counter, rate float64
+	sheet      pixel.Picture
+	anim       animationPlayer
+	frame      pixel.Rect
+	frameWidth float64
 }
 
 func (r *room) init(path string) {
 	if r.animLayer {
-		var err error
-
-		r.sheet, r.anims, err = loadAnimationSheet("world-layer-animation", 1400, filepath.Join("images", "world", "rooms"))
+		sheet, anims, err := assets.sheet(r.asset)
 
 		if err != nil {
 			panic(err)
 		}
 
-		r.imd = imdraw.New(r.sheet)
-
-		r.sprite = pixel.NewSprite(nil, pixel.Rect{})
+		r.setSheet(sheet, anims)
 	}
 
 	for _, wall := range r.walls {
@@ -407,27 +353,47 @@ func (r *room) init(path string) {
 	}
 
 	if !r.animLayer {
-		var err error
-
-		r.img, err = loadPicture(filepath.Join("images", "world", "rooms") + path)
+		img, err := assets.picture(r.asset)
 		if err != nil {
 			panic(err)
 		}
 
-		r.sprite = pixel.NewSprite(r.img, r.img.Bounds())
-		r.drawnRoom = imdraw.New(r.img)
+		r.setPicture(img)
+	}
+}
 
-		r.imd = imdraw.New(nil)
+func (r *room) setSheet(sheet pixel.Picture, anims map[string]*animation) {
+	r.sheet = sheet
+	r.anim.setAnimations(anims)
+	r.anim.play("Norm")
+	r.imd = imdraw.New(r.sheet)
 
-		r.draw(r.drawnRoom)
+	r.sprite = pixel.NewSprite(nil, pixel.Rect{})
+}
+
+func (r *room) setPicture(img pixel.Picture) {
+	r.img = img
+	r.sprite = pixel.NewSprite(r.img, r.img.Bounds())
+	r.drawnRoom = imdraw.New(r.img)
+
+	r.imd = imdraw.New(nil)
+
+	r.draw(r.drawnRoom)
+}
+
+// reloadAssets swaps in the room's picture or sheet, after it's been reloaded.
+func (r *room) reloadAssets() {
+	switch v := assets.current(r.asset).(type) {
+	case *animationSheet:
+		r.setSheet(v.sheet, v.anims)
+	case pixel.Picture:
+		r.setPicture(v)
 	}
 }
 
 func (r *room) update(dt float64) {
-	r.counter += dt
-
-	i := int(math.Floor(r.counter / r.rate / 2))
-	r.frame = r.anims["Norm"][i%len(r.anims["Norm"])]
+	r.anim.update(dt)
+	r.frame = r.anim.frame()
 }
 
 func (r *room) draw(t pixel.Target) {
@@ -446,13 +412,13 @@ func (r *room) draw(t pixel.Target) {
 func (r *room) animDraw(t pixel.Target) {
 	r.imd.Clear()
 	r.sprite.Set(r.sheet, r.frame)
-	r.sprite.Draw(r.imd, pixel.IM)
+	r.sprite.Draw(r.imd, pixel.IM.Moved(r.offset))
 
 	r.imd.Draw(t)
 }
 
-func loadPicture(path string) (pixel.Picture, error) {
-	file, err := os.Open(path + ".png")
+func loadPicture(fsys fs.FS, path string) (pixel.Picture, error) {
+	file, err := fsys.Open(path + ".png")
 	if err != nil {
 		return nil, err
 	}
@@ -491,3 +457,11 @@ func (w *wall) Vel() pixel.Vec {
 func (w *wall) HandleCollision(c Collidable, collisionTime float64, normal pixel.Vec) {
 
 }
+
+func (w *wall) Layer() collisionLayer {
+	return layerStatic
+}
+
+func (w *wall) Mask() collisionLayer {
+	return 0 // walls don't react to anything
+}